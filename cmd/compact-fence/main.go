@@ -0,0 +1,100 @@
+// Command compact-fence compacts the H3 cells of a privacy fence before it is
+// published, which shrinks the fence table and speeds up its recovery.
+//
+// It reads a fence from the file named by its argument, or from standard
+// input, and writes the result to standard output. The fence may be bare
+// FenceData or a CloudEvent carrying it, as stored in the fence table.
+//
+//	compact-fence fence.json > compacted.json
+//	compact-fence -uncompact < compacted.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DIMO-Network/privacy-processor/internal/processors"
+	"github.com/DIMO-Network/shared"
+)
+
+func main() {
+	uncompact := flag.Bool("uncompact", false, "expand compacted cells back to their cell resolution")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-uncompact] [file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Arg(0), *uncompact, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "compact-fence: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, uncompact bool, stdin io.Reader, stdout, stderr io.Writer) error {
+	in := stdin
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	raw, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return err
+	}
+
+	convert := processors.CompactFence
+	if uncompact {
+		convert = processors.UncompactFence
+	}
+
+	var before, after processors.FenceData
+	var out any
+	if probe.SpecVersion != "" {
+		var event shared.CloudEvent[processors.FenceData]
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return err
+		}
+		before = event.Data
+		if event.Data, err = convert(event.Data); err != nil {
+			return err
+		}
+		after, out = event.Data, event
+	} else {
+		if err := json.Unmarshal(raw, &before); err != nil {
+			return err
+		}
+		if after, err = convert(before); err != nil {
+			return err
+		}
+		out = after
+	}
+
+	fmt.Fprintf(stderr, "%d cells before, %d after\n", countCells(before), countCells(after))
+
+	enc := json.NewEncoder(stdout)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(out)
+}
+
+func countCells(data processors.FenceData) int {
+	n := len(data.H3Indexes)
+	for _, z := range data.Zones {
+		n += len(z.H3Indexes)
+	}
+	return n
+}
@@ -2,29 +2,91 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Privacy zone schedules name IANA time zones.
 
 	"github.com/DIMO-Network/privacy-processor/internal/config"
+	"github.com/DIMO-Network/privacy-processor/internal/health"
 	"github.com/DIMO-Network/privacy-processor/internal/processors"
+	"github.com/DIMO-Network/privacy-processor/pkg/attestation"
 	"github.com/DIMO-Network/shared"
 	"github.com/IBM/sarama"
 	"github.com/burdiyan/kafkautil"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/lovoo/goka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
 )
 
-func serveMonitoring(port string, logger *zerolog.Logger) {
+// defaultShutdownTimeout is used when SHUTDOWN_TIMEOUT_SECONDS is unset. It
+// leaves headroom within the default Kubernetes grace period of 30 seconds.
+const defaultShutdownTimeout = 25 * time.Second
+
+// defaultGlobalFenceRefresh is used when GLOBAL_FENCE_REFRESH_SECONDS is
+// unset. It is how often global fences are reloaded from their topic.
+const defaultGlobalFenceRefresh = time.Minute
+
+// trajectoryFlushInterval is how often V2 events held back for trajectory
+// redaction are checked for release.
+const trajectoryFlushInterval = 10 * time.Second
+
+func serveMonitoring(web *fiber.App, port string, logger *zerolog.Logger) {
 	logger.Info().Msg("Listening for health check on port " + port)
 
+	if err := web.Listen(":" + port); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start monitoring server on port " + port)
+	}
+}
+
+func newMonitoringApp(checker *health.Checker) *fiber.App {
 	web := fiber.New(fiber.Config{DisableStartupMessage: true})
 	web.Get("/", func(_ *fiber.Ctx) error {
 		return nil
 	})
+	web.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+	checker.Register(web)
+	return web
+}
 
-	if err := web.Listen(":" + port); err != nil {
-		logger.Fatal().Err(err).Msg("Failed to start monitoring server on port " + port)
+// runProcessor runs proc until ctx is cancelled. Stopping because of the
+// cancellation is not an error, but stopping for any other reason is.
+func runProcessor(ctx context.Context, name string, proc *goka.Processor) error {
+	err := proc.Run(ctx)
+	if ctx.Err() != nil && (err == nil || errors.Is(err, context.Canceled)) {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("stopped unexpectedly")
+	}
+	return fmt.Errorf("privacy processor %s: %w", name, err)
+}
+
+// flushTrajectories periodically releases V2 events that have been held back
+// for vehicles that stopped reporting, until ctx is cancelled.
+func flushTrajectories(ctx context.Context, g *processors.PrivacyV2, proc *goka.Processor, logger *zerolog.Logger) {
+	ticker := time.NewTicker(trajectoryFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// Fails while the processor is starting or rebalancing, the next
+			// tick will catch up.
+			if err := g.FlushTrajectories(ctx, proc, now); err != nil && ctx.Err() == nil {
+				logger.Warn().Err(err).Msg("Couldn't release held V2 events.")
+			}
+		}
 	}
 }
 
@@ -45,63 +107,404 @@ func main() {
 	}
 	zerolog.SetGlobalLevel(logLevel)
 
-	go serveMonitoring(settings.Port, &logger)
-
 	gokaConfig := goka.DefaultConfig()
 	gokaConfig.Version = sarama.V2_8_1_0
 
 	goka.ReplaceGlobalConfig(gokaConfig)
 
+	brokers := strings.Split(settings.KafkaBrokers, ",")
+
+	healthConfig := sarama.NewConfig()
+	healthConfig.Version = sarama.V2_8_1_0
+	healthConfig.Net.DialTimeout = 3 * time.Second
+	healthConfig.Metadata.Retry.Max = 0
+	checker := health.NewChecker(health.KafkaCheck(brokers, healthConfig))
+
+	redactor, err := processors.NewRedactor(processors.Redaction{
+		Strategy:     settings.DefaultRedaction,
+		Resolution:   settings.DefaultRedactionResolution,
+		RadiusMeters: float64(settings.DefaultRedactionNoiseRadiusMeters),
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid default redaction")
+	}
+
+	outputs, err := processors.ParseOutputs(settings.DeviceStatusOutputs)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid status outputs")
+	}
+
+	outputsV2, err := processors.ParseOutputs(settings.DeviceStatusOutputsV2)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid V2 status outputs")
+	}
+
+	locationSignals, err := processors.ParseLocationSignals(settings.LocationSignalsV2)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid V2 location signals")
+	}
+
+	orphanPolicy, err := processors.ParseOrphanPolicy(settings.LocationOrphanPolicy)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid V2 location orphan policy")
+	}
+
+	filter, err := processors.ParseFilter(settings.SignalDenylist, settings.SignalAllowlist)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid signal filter")
+	}
+
+	filterV2, err := processors.ParseFilter(settings.SignalDenylistV2, settings.SignalAllowlistV2)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid V2 signal filter")
+	}
+
+	devicePolicy, err := processors.ParseFieldPolicy(settings.DeviceFieldPolicyV2)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid V2 device field policy")
+	}
+
+	extensionPolicy, err := processors.ParseFieldPolicy(settings.ExtensionFieldPolicyV2)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid V2 extension field policy")
+	}
+
+	scrubber, err := processors.NewScrubber(devicePolicy, extensionPolicy, []byte(settings.FieldHMACKey))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid V2 field policies")
+	}
+
+	var signer *attestation.Signer
+	switch {
+	case settings.SigningKeyFile != "":
+		signer, err = attestation.LoadSigner(settings.SigningKeyFile)
+	case settings.SigningKey != "":
+		signer, err = attestation.NewSigner(settings.SigningKey)
+	}
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid signing key")
+	}
+	if signer != nil {
+		logger.Info().Msgf("Signing V2 events as %s", signer.Address())
+	} else {
+		logger.Warn().Msg("No signing key, V2 events will be emitted unsigned.")
+	}
+
+	var globalFences *processors.GlobalFences
+	var globalView *goka.View
+	switch {
+	case settings.GlobalFenceFile != "" && settings.GlobalFenceTopic != "":
+		logger.Fatal().Msg("Only one of GLOBAL_FENCE_FILE and GLOBAL_FENCE_TOPIC may be set")
+	case settings.GlobalFenceFile != "":
+		globalFences, err = processors.LoadGlobalFences(settings.GlobalFenceFile)
+		if globalFences == nil {
+			logger.Fatal().Err(err).Msg("Failed to load global fences")
+		}
+		if err != nil {
+			logger.Err(err).Msg("Global fences have invalid shapes, ignoring them.")
+		}
+	case settings.GlobalFenceTopic != "":
+		globalFences = new(processors.GlobalFences)
+		globalView, err = goka.NewView(brokers, goka.Table(settings.GlobalFenceTopic), new(shared.JSONCodec[shared.CloudEvent[processors.FenceData]]), goka.WithViewHasher(kafkautil.MurmurHasher))
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to create global fence view")
+		}
+		checker.AddView("globalFences", globalView)
+	}
+
+	precedence, err := processors.ParseFencePrecedence(settings.FencePrecedence)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid fence precedence")
+	}
+
+	merge, err := processors.ParseMergePolicy(settings.FenceMerge)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid fence merge policy")
+	}
+
+	fenceLimits := processors.FenceLimits{
+		MinResolution: settings.FenceMinResolution,
+		MaxResolution: settings.FenceMaxResolution,
+		MaxCells:      settings.FenceMaxCells,
+		MaxAreaKm2:    float64(settings.FenceMaxAreaKm2),
+	}
+
+	// inheritance returns the fence inheritance for a pipeline, or nil if it
+	// has no mapping table.
+	inheritance := func(mappingTopic string) *processors.Inheritance {
+		if mappingTopic == "" {
+			return nil
+		}
+		return &processors.Inheritance{
+			MappingTable:    goka.Table(mappingTopic),
+			OwnerFenceTable: goka.Table(settings.OwnerFenceTopic),
+			FleetFenceTable: goka.Table(settings.FleetFenceTopic),
+			Precedence:      precedence,
+			Merge:           merge,
+		}
+	}
+
 	fg := processors.Privacy{
-		Group:        goka.Group(settings.PrivacyProcessorConsumerGroup),
-		StatusInput:  goka.Stream(settings.DeviceStatusTopic),
-		FenceTable:   goka.Table(settings.PrivacyFenceTopic),
-		StatusOutput: goka.Stream(settings.DeviceStatusPrivateTopic),
-		Logger:       &logger,
+		Group:             goka.Group(settings.PrivacyProcessorConsumerGroup),
+		StatusInput:       goka.Stream(settings.DeviceStatusTopic),
+		FenceTable:        goka.Table(settings.PrivacyFenceTopic),
+		StatusOutput:      goka.Stream(settings.DeviceStatusPrivateTopic),
+		Outputs:           outputs,
+		Redactor:          redactor,
+		DeadLetterOutput:  goka.Stream(settings.DeadLetterTopic),
+		Filter:            filter,
+		GlobalFences:      globalFences,
+		Inheritance:       inheritance(settings.VehicleMappingTopic),
+		FenceLimits:       fenceLimits,
+		FenceErrorsOutput: goka.Stream(settings.FenceErrorsTopic),
+		FenceFailClosed:   settings.FenceFailClosed,
+		FenceHistory:      settings.FenceHistory,
+		Logger:            &logger,
 	}
 
 	fgg := fg.Define()
 
-	p, err := goka.NewProcessor(strings.Split(settings.KafkaBrokers, ","), fgg, goka.WithHasher(kafkautil.MurmurHasher))
+	p, err := goka.NewProcessor(brokers, fgg, goka.WithHasher(kafkautil.MurmurHasher))
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create privacy processor")
 	}
+	prometheus.MustRegister(processors.NewProcessorCollector(processors.PipelineV1, p))
+	checker.AddProcessor(processors.PipelineV1, p)
 
-	web := fiber.New(fiber.Config{DisableStartupMessage: true})
-	web.Get("/", func(_ *fiber.Ctx) error {
-		return nil
-	})
-
-	logger.Info().Msg("Starting privacy processor")
-	logger.Info().Msgf("Input topic %s, joining with table %s", settings.DeviceStatusTopic, settings.PrivacyFenceTopic)
-	logger.Info().Msgf("Output topic %s", settings.DeviceStatusPrivateTopic)
+	var tripEndpoints *processors.TripEndpoints
+	if settings.TripEndpointWindowSeconds > 0 || settings.TripEndpointMeters > 0 {
+		if res := settings.TripEndpointResolution; res < 0 || res > 15 {
+			logger.Fatal().Msgf("Invalid trip endpoint resolution %d", res)
+		}
+		tripEndpoints = &processors.TripEndpoints{
+			Signal:      settings.TripEndpointSignal,
+			Window:      time.Duration(settings.TripEndpointWindowSeconds) * time.Second,
+			Meters:      float64(settings.TripEndpointMeters),
+			Resolution:  settings.TripEndpointResolution,
+			SpeedSignal: settings.TripEndpointSpeedSignal,
+			MovingSpeed: float64(settings.TripEndpointMovingSpeed),
+			ParkedAfter: time.Duration(settings.TripEndpointParkedSeconds) * time.Second,
+		}
+	}
 
-	go func() {
-		if err := p.Run(context.Background()); err != nil {
-			logger.Fatal().Err(err).Msg("Failed to start privacy processor")
+	var trajectory *processors.Trajectory
+	if settings.TrajectoryWindowSeconds > 0 || settings.TrajectoryMeters > 0 || tripEndpoints != nil {
+		trajectory = &processors.Trajectory{
+			Window:        time.Duration(settings.TrajectoryWindowSeconds) * time.Second,
+			Meters:        float64(settings.TrajectoryMeters),
+			Delay:         time.Duration(settings.TrajectoryDelaySeconds) * time.Second,
+			MaxEvents:     settings.TrajectoryMaxEvents,
+			TripEndpoints: tripEndpoints,
 		}
-	}()
+		if err := trajectory.Check(); err != nil {
+			logger.Fatal().Err(err).Msg("Invalid trajectory settings")
+		}
+	}
 
 	// V2
 	fgV2 := processors.PrivacyV2{
-		Group:        goka.Group(settings.PrivacyProcessorConsumerGroupV2),
-		StatusInput:  goka.Stream(settings.DeviceStatusTopicV2),
-		FenceTable:   goka.Table(settings.PrivacyFenceTopicV2),
-		StatusOutput: goka.Stream(settings.DeviceStatusPrivateTopicV2),
-		Logger:       &logger,
+		Group:             goka.Group(settings.PrivacyProcessorConsumerGroupV2),
+		StatusInput:       goka.Stream(settings.DeviceStatusTopicV2),
+		FenceTable:        goka.Table(settings.PrivacyFenceTopicV2),
+		StatusOutput:      goka.Stream(settings.DeviceStatusPrivateTopicV2),
+		Outputs:           outputsV2,
+		Redactor:          redactor,
+		DeadLetterOutput:  goka.Stream(settings.DeadLetterTopicV2),
+		Filter:            filterV2,
+		LocationSignals:   locationSignals,
+		PairTolerance:     time.Duration(settings.LocationPairToleranceMillis) * time.Millisecond,
+		OrphanPolicy:      orphanPolicy,
+		FailClosed:        settings.LocationFailClosed,
+		Scrubber:          scrubber,
+		Signer:            signer,
+		GlobalFences:      globalFences,
+		Inheritance:       inheritance(settings.VehicleMappingTopicV2),
+		FenceLimits:       fenceLimits,
+		FenceErrorsOutput: goka.Stream(settings.FenceErrorsTopicV2),
+		FenceFailClosed:   settings.FenceFailClosed,
+		FenceHistory:      settings.FenceHistory,
+		Trajectory:        trajectory,
+		Logger:            &logger,
 	}
 
 	fggV2 := fgV2.DefineV2()
-	pV2, err := goka.NewProcessor(strings.Split(settings.KafkaBrokers, ","), fggV2, goka.WithHasher(kafkautil.MurmurHasher))
+	pV2, err := goka.NewProcessor(brokers, fggV2, goka.WithHasher(kafkautil.MurmurHasher))
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to create privacy processor")
 	}
+	prometheus.MustRegister(processors.NewProcessorCollector(processors.PipelineV2, pV2))
+	checker.AddProcessor(processors.PipelineV2, pV2)
+
+	var pSuggestions *goka.Processor
+	if settings.ZoneSuggestionsTopic != "" {
+		if settings.ZoneSuggestionsConsumerGroup == "" {
+			logger.Fatal().Msg("ZONE_SUGGESTIONS_CONSUMER_GROUP must be set along with ZONE_SUGGESTIONS_TOPIC")
+		}
+		if res := settings.ZoneSuggestionsResolution; res < 0 || res > 15 {
+			logger.Fatal().Msgf("Invalid zone suggestion resolution %d", res)
+		}
+
+		location := time.UTC
+		if settings.ZoneSuggestionsTimeZone != "" {
+			location, err = time.LoadLocation(settings.ZoneSuggestionsTimeZone)
+			if err != nil {
+				logger.Fatal().Err(err).Msg("Invalid zone suggestion time zone")
+			}
+		}
+
+		sg := processors.Suggestions{
+			Group:             goka.Group(settings.ZoneSuggestionsConsumerGroup),
+			StatusInput:       goka.Stream(settings.DeviceStatusTopicV2),
+			FenceTable:        goka.Table(settings.PrivacyFenceTopicV2),
+			SuggestionsOutput: goka.Stream(settings.ZoneSuggestionsTopic),
+			Resolution:        settings.ZoneSuggestionsResolution,
+			MinDwell:          time.Duration(settings.ZoneSuggestionsMinDwellSeconds) * time.Second,
+			MaxGap:            time.Duration(settings.ZoneSuggestionsMaxGapSeconds) * time.Second,
+			Location:          location,
+			Top:               settings.ZoneSuggestionsTop,
+			LocationSignals:   locationSignals,
+			Logger:            &logger,
+		}
+
+		pSuggestions, err = goka.NewProcessor(brokers, sg.Define(), goka.WithHasher(kafkautil.MurmurHasher))
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to create zone suggestions processor")
+		}
+		prometheus.MustRegister(processors.NewProcessorCollector(processors.PipelineSuggestions, pSuggestions))
+		checker.AddProcessor(processors.PipelineSuggestions, pSuggestions)
+	}
+
+	web := newMonitoringApp(checker)
+	go serveMonitoring(web, settings.Port, &logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// If any pipeline fails, the others are stopped as well.
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	// Global fences must be in place before any status is processed, or
+	// locations in them would go out in the clear.
+	switch {
+	case globalView != nil:
+		refresh := defaultGlobalFenceRefresh
+		if settings.GlobalFenceRefreshSeconds > 0 {
+			refresh = time.Duration(settings.GlobalFenceRefreshSeconds) * time.Second
+		}
+		logger.Info().Msgf("Reading global fences from table %s every %s", settings.GlobalFenceTopic, refresh)
+
+		group.Go(func() error {
+			err := globalView.Run(groupCtx)
+			if groupCtx.Err() != nil {
+				return nil
+			}
+			if err == nil {
+				err = errors.New("stopped unexpectedly")
+			}
+			return fmt.Errorf("global fence view: %w", err)
+		})
+
+		if err := globalFences.LoadFromView(groupCtx, globalView); err != nil && groupCtx.Err() == nil {
+			logger.Err(err).Msg("Problem loading global fences.")
+		}
+		go globalFences.Sync(groupCtx, globalView, refresh, &logger)
+	case globalFences != nil:
+		logger.Info().Msgf("Read global fences from %s", settings.GlobalFenceFile)
+	}
+
+	logger.Info().Msg("Starting privacy processor")
+	logger.Info().Msgf("Input topic %s, joining with table %s", settings.DeviceStatusTopic, settings.PrivacyFenceTopic)
+	logger.Info().Msgf("Output topic %s", settings.DeviceStatusPrivateTopic)
+	for _, o := range outputs {
+		logger.Info().Msgf("Output topic %s with policy %s", o.Stream, o.Policy.Level)
+	}
+	if settings.DeadLetterTopic != "" {
+		logger.Info().Msgf("Dead-letter topic %s", settings.DeadLetterTopic)
+	} else {
+		logger.Warn().Msg("No dead-letter topic, unprocessable messages will only be logged.")
+	}
+
+	if settings.FenceErrorsTopic != "" {
+		logger.Info().Msgf("Fence errors topic %s", settings.FenceErrorsTopic)
+	}
+	if fg.Inheritance != nil {
+		logger.Info().Msgf("Inheriting fences through table %s, %s by %s", settings.VehicleMappingTopic, merge, strings.Join(precedence, ", "))
+	}
+
+	group.Go(func() error {
+		return runProcessor(groupCtx, processors.PipelineV1, p)
+	})
 
 	logger.Info().Msg("Starting privacy processor V2")
 	logger.Info().Msgf("Input topic %s, joining with table %s", settings.DeviceStatusTopicV2, settings.PrivacyFenceTopicV2)
 	logger.Info().Msgf("Output topic %s", settings.DeviceStatusPrivateTopicV2)
+	for _, o := range outputsV2 {
+		logger.Info().Msgf("Output topic %s with policy %s", o.Stream, o.Policy.Level)
+	}
+	if settings.DeadLetterTopicV2 != "" {
+		logger.Info().Msgf("Dead-letter topic %s", settings.DeadLetterTopicV2)
+	} else {
+		logger.Warn().Msg("No dead-letter topic, unprocessable messages will only be logged.")
+	}
+
+	if settings.FenceErrorsTopicV2 != "" {
+		logger.Info().Msgf("Fence errors topic %s", settings.FenceErrorsTopicV2)
+	}
+	if fgV2.Inheritance != nil {
+		logger.Info().Msgf("Inheriting fences through table %s, %s by %s", settings.VehicleMappingTopicV2, merge, strings.Join(precedence, ", "))
+	}
+
+	group.Go(func() error {
+		return runProcessor(groupCtx, processors.PipelineV2, pV2)
+	})
 
-	if err := pV2.Run(context.Background()); err != nil {
-		logger.Fatal().Err(err).Msg("Failed to start privacy processor V2")
+	if trajectory != nil {
+		logger.Info().Msgf("Redacting V2 locations within %s or %dm of zones", trajectory.Window, settings.TrajectoryMeters)
+		if tripEndpoints != nil {
+			logger.Info().Msgf("Coarsening V2 locations within %s or %dm of trip endpoints", tripEndpoints.Window, settings.TripEndpointMeters)
+		}
+		go flushTrajectories(groupCtx, &fgV2, pV2, &logger)
+	}
+
+	if pSuggestions != nil {
+		logger.Info().Msg("Starting zone suggestions processor")
+		logger.Info().Msgf("Input topic %s, output topic %s", settings.DeviceStatusTopicV2, settings.ZoneSuggestionsTopic)
+
+		group.Go(func() error {
+			return runProcessor(groupCtx, processors.PipelineSuggestions, pSuggestions)
+		})
+	}
+
+	<-groupCtx.Done()
+	// Restore default signal handling so that a second signal kills us.
+	stop()
+
+	shutdownTimeout := defaultShutdownTimeout
+	if settings.ShutdownTimeoutSeconds > 0 {
+		shutdownTimeout = time.Duration(settings.ShutdownTimeoutSeconds) * time.Second
+	}
+
+	logger.Info().Msgf("Shutting down, waiting up to %s for pipelines to drain.", shutdownTimeout)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- group.Wait()
+	}()
+
+	var runErr error
+	select {
+	case runErr = <-drained:
+	case <-time.After(shutdownTimeout):
+		runErr = fmt.Errorf("pipelines did not stop within %s", shutdownTimeout)
 	}
+
+	if err := web.ShutdownWithTimeout(5 * time.Second); err != nil {
+		logger.Err(err).Msg("Failed to shut down monitoring server.")
+	}
+
+	if runErr != nil {
+		logger.Fatal().Err(runErr).Msg("Privacy processor failed.")
+	}
+
+	logger.Info().Msg("Shutdown complete.")
 }
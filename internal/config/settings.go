@@ -10,9 +10,99 @@ type Settings struct {
 	DeviceStatusTopic             string `yaml:"DEVICE_STATUS_TOPIC"`
 	PrivacyFenceTopic             string `yaml:"PRIVACY_FENCE_TOPIC"`
 	DeviceStatusPrivateTopic      string `yaml:"DEVICE_STATUS_PRIVATE_TOPIC"`
+	// DeviceStatusOutputs lists additional tiers as topic:policy pairs. See processors.ParseOutputs.
+	DeviceStatusOutputs string `yaml:"DEVICE_STATUS_OUTPUTS"`
 	// V2
 	PrivacyProcessorConsumerGroupV2 string `yaml:"PRIVACY_PROCESSOR_CONSUMER_GROUP_V2"`
 	DeviceStatusTopicV2             string `yaml:"DEVICE_STATUS_TOPIC_V2"`
 	DeviceStatusPrivateTopicV2      string `yaml:"DEVICE_STATUS_PRIVATE_TOPIC_V2"`
 	PrivacyFenceTopicV2             string `yaml:"PRIVACY_FENCE_TOPIC_V2"`
+	DeviceStatusOutputsV2           string `yaml:"DEVICE_STATUS_OUTPUTS_V2"`
+	// Redaction for zones that don't choose their own. See processors.Redaction.
+	DefaultRedaction                  string `yaml:"DEFAULT_REDACTION"`
+	DefaultRedactionResolution        int    `yaml:"DEFAULT_REDACTION_RESOLUTION"`
+	DefaultRedactionNoiseRadiusMeters int    `yaml:"DEFAULT_REDACTION_NOISE_RADIUS_METERS"`
+	// ShutdownTimeoutSeconds bounds how long pipelines may take to drain on shutdown.
+	ShutdownTimeoutSeconds int `yaml:"SHUTDOWN_TIMEOUT_SECONDS"`
+	// Dead-letter topics for messages that can't be processed. Optional.
+	DeadLetterTopic   string `yaml:"DEAD_LETTER_TOPIC"`
+	DeadLetterTopicV2 string `yaml:"DEAD_LETTER_TOPIC_V2"`
+	// LocationSignalsV2 lists V2 location signal names as latitude:longitude[:companion...]
+	// groups. See processors.ParseLocationSignals.
+	LocationSignalsV2 string `yaml:"LOCATION_SIGNALS_V2"`
+	// LocationPairToleranceMillis is how far apart a V2 latitude and longitude may be and still be paired.
+	LocationPairToleranceMillis int `yaml:"LOCATION_PAIR_TOLERANCE_MILLIS"`
+	// LocationOrphanPolicy handles V2 coordinates that can't be paired. See processors.ParseOrphanPolicy.
+	LocationOrphanPolicy string `yaml:"LOCATION_ORPHAN_POLICY"`
+	// LocationFailClosed removes V2 coordinates that aren't numbers instead of passing them through.
+	LocationFailClosed bool `yaml:"LOCATION_FAIL_CLOSED"`
+	// Hex-encoded secp256k1 key for signing redacted V2 events, given directly or as a file path.
+	// SigningKeyFile takes precedence. Events are emitted unsigned if neither is set.
+	SigningKey     string `yaml:"SIGNING_KEY"`
+	SigningKeyFile string `yaml:"SIGNING_KEY_FILE"`
+	// Field policies for the V2 device block and CloudEvent extensions. See processors.ParseFieldPolicy.
+	DeviceFieldPolicyV2    string `yaml:"DEVICE_FIELD_POLICY_V2"`
+	ExtensionFieldPolicyV2 string `yaml:"EXTENSION_FIELD_POLICY_V2"`
+	// FieldHMACKey keys the hmac field action.
+	FieldHMACKey string `yaml:"FIELD_HMAC_KEY"`
+	// Signal filters for V2 signals and V1 overflow keys. See processors.ParseFilter.
+	SignalDenylist    string `yaml:"SIGNAL_DENYLIST"`
+	SignalAllowlist   string `yaml:"SIGNAL_ALLOWLIST"`
+	SignalDenylistV2  string `yaml:"SIGNAL_DENYLIST_V2"`
+	SignalAllowlistV2 string `yaml:"SIGNAL_ALLOWLIST_V2"`
+	// Trajectory redaction around V2 privacy zones. It is enabled if either the window or the
+	// distance is set, and the distance alone needs a delay. See processors.Trajectory.
+	TrajectoryWindowSeconds int `yaml:"TRAJECTORY_WINDOW_SECONDS"`
+	TrajectoryMeters        int `yaml:"TRAJECTORY_METERS"`
+	TrajectoryDelaySeconds  int `yaml:"TRAJECTORY_DELAY_SECONDS"`
+	TrajectoryMaxEvents     int `yaml:"TRAJECTORY_MAX_EVENTS"`
+	// Coarsening around V2 trip endpoints, for vehicles whose fence asks for it. It is enabled
+	// if either the window or the distance is set. See processors.TripEndpoints.
+	TripEndpointSignal        string `yaml:"TRIP_ENDPOINT_SIGNAL"`
+	TripEndpointWindowSeconds int    `yaml:"TRIP_ENDPOINT_WINDOW_SECONDS"`
+	TripEndpointMeters        int    `yaml:"TRIP_ENDPOINT_METERS"`
+	TripEndpointResolution    int    `yaml:"TRIP_ENDPOINT_RESOLUTION"`
+	// Vehicles that don't report ignition are followed by speed, in km/h for the default signal.
+	// TRAJECTORY_DELAY_SECONDS must be at least TRIP_ENDPOINT_PARKED_SECONDS, or five minutes
+	// if that is unset.
+	TripEndpointSpeedSignal   string `yaml:"TRIP_ENDPOINT_SPEED_SIGNAL"`
+	TripEndpointMovingSpeed   int    `yaml:"TRIP_ENDPOINT_MOVING_SPEED"`
+	TripEndpointParkedSeconds int    `yaml:"TRIP_ENDPOINT_PARKED_SECONDS"`
+	// Zone suggestions from V2 dwell statistics. Enabled if the topic is set. See processors.Suggestions.
+	ZoneSuggestionsConsumerGroup   string `yaml:"ZONE_SUGGESTIONS_CONSUMER_GROUP"`
+	ZoneSuggestionsTopic           string `yaml:"ZONE_SUGGESTIONS_TOPIC"`
+	ZoneSuggestionsResolution      int    `yaml:"ZONE_SUGGESTIONS_RESOLUTION"`
+	ZoneSuggestionsMinDwellSeconds int    `yaml:"ZONE_SUGGESTIONS_MIN_DWELL_SECONDS"`
+	ZoneSuggestionsTop             int    `yaml:"ZONE_SUGGESTIONS_TOP"`
+	ZoneSuggestionsMaxGapSeconds   int    `yaml:"ZONE_SUGGESTIONS_MAX_GAP_SECONDS"`
+	// ZoneSuggestionsTimeZone is the IANA time zone in which stays are counted by hour. Defaults to UTC.
+	ZoneSuggestionsTimeZone string `yaml:"ZONE_SUGGESTIONS_TIME_ZONE"`
+	// Global fences that apply to every vehicle, read from a JSON file of fence IDs to fence data
+	// or from a compacted topic keyed by fence ID. At most one may be set. See processors.GlobalFences.
+	GlobalFenceFile           string `yaml:"GLOBAL_FENCE_FILE"`
+	GlobalFenceTopic          string `yaml:"GLOBAL_FENCE_TOPIC"`
+	GlobalFenceRefreshSeconds int    `yaml:"GLOBAL_FENCE_REFRESH_SECONDS"`
+	// Fences inherited from the vehicle's owner and fleet. Enabled for a pipeline if its mapping
+	// topic is set. See processors.Inheritance.
+	VehicleMappingTopic   string `yaml:"VEHICLE_MAPPING_TOPIC"`
+	VehicleMappingTopicV2 string `yaml:"VEHICLE_MAPPING_TOPIC_V2"`
+	OwnerFenceTopic       string `yaml:"OWNER_FENCE_TOPIC"`
+	FleetFenceTopic       string `yaml:"FLEET_FENCE_TOPIC"`
+	// FencePrecedence orders the vehicle, owner and fleet fences. See processors.ParseFencePrecedence.
+	FencePrecedence string `yaml:"FENCE_PRECEDENCE"`
+	// FenceMerge is union or override. See processors.ParseMergePolicy.
+	FenceMerge string `yaml:"FENCE_MERGE"`
+	// Fence validation. Zero limits use the defaults. See processors.FenceLimits.
+	FenceMinResolution int `yaml:"FENCE_MIN_RESOLUTION"`
+	FenceMaxResolution int `yaml:"FENCE_MAX_RESOLUTION"`
+	FenceMaxCells      int `yaml:"FENCE_MAX_CELLS"`
+	FenceMaxAreaKm2    int `yaml:"FENCE_MAX_AREA_KM2"`
+	// Topics that receive reports of invalid fences. Optional.
+	FenceErrorsTopic   string `yaml:"FENCE_ERRORS_TOPIC"`
+	FenceErrorsTopicV2 string `yaml:"FENCE_ERRORS_TOPIC_V2"`
+	// FenceFailClosed removes every location of vehicles whose fence is invalid.
+	FenceFailClosed bool `yaml:"FENCE_FAIL_CLOSED"`
+	// FenceHistory is the number of versions of each vehicle's fence kept, so that events are
+	// redacted with the version effective at their time. Zero uses the current fence.
+	FenceHistory int `yaml:"FENCE_HISTORY"`
 }
@@ -0,0 +1,251 @@
+// Package health reports liveness and readiness of the privacy processors.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lovoo/goka"
+)
+
+// checkTimeout bounds the time a probe spends gathering processor stats and
+// contacting the brokers.
+const checkTimeout = 5 * time.Second
+
+// Processor is the part of *goka.Processor that the checker inspects.
+type Processor interface {
+	StateReader() goka.StateReader
+	StatsWithContext(ctx context.Context) *goka.ProcessorStats
+}
+
+// View is the part of *goka.View that the checker inspects.
+type View interface {
+	Recovered() bool
+}
+
+// Report is the body of a health response.
+type Report struct {
+	Status     string                     `json:"status"`
+	Processors map[string]ProcessorReport `json:"processors"`
+	// Views are "recovered" or "recovering".
+	Views   map[string]string `json:"views,omitempty"`
+	Brokers string            `json:"brokers,omitempty"`
+}
+
+// ProcessorReport describes a single processor.
+type ProcessorReport struct {
+	State  string                 `json:"state"`
+	Tables map[string]TableReport `json:"tables,omitempty"`
+}
+
+// TableReport describes the recovery of one of a processor's tables across
+// the partitions assigned to this instance.
+type TableReport struct {
+	Partitions int `json:"partitions"`
+	Recovered  int `json:"recovered"`
+	// Remaining is the number of messages left to recover.
+	Remaining int64 `json:"remaining"`
+}
+
+// Checker derives health from the state of a set of processors.
+type Checker struct {
+	names     []string
+	procs     map[string]Processor
+	viewNames []string
+	views     map[string]View
+	brokers   func(context.Context) error
+}
+
+// NewChecker returns a checker that additionally requires brokers to succeed
+// for readiness. brokers may be nil.
+func NewChecker(brokers func(context.Context) error) *Checker {
+	return &Checker{procs: make(map[string]Processor), views: make(map[string]View), brokers: brokers}
+}
+
+// AddProcessor adds a processor under the given name.
+func (c *Checker) AddProcessor(name string, p Processor) {
+	c.names = append(c.names, name)
+	c.procs[name] = p
+}
+
+// AddView adds a view under the given name. It must have recovered for
+// readiness.
+func (c *Checker) AddView(name string, v View) {
+	c.viewNames = append(c.viewNames, name)
+	c.views[name] = v
+}
+
+// Register adds the /health/live and /health/ready endpoints to app.
+func (c *Checker) Register(app *fiber.App) {
+	app.Get("/health/live", func(ctx *fiber.Ctx) error {
+		return respond(ctx, c.Live)
+	})
+	app.Get("/health/ready", func(ctx *fiber.Ctx) error {
+		return respond(ctx, c.Ready)
+	})
+}
+
+func respond(ctx *fiber.Ctx, check func(context.Context) (Report, bool)) error {
+	report, ok := check(ctx.UserContext())
+	if !ok {
+		ctx.Status(fiber.StatusServiceUnavailable)
+	}
+	return ctx.JSON(report)
+}
+
+// Live reports whether every processor is still running or getting there. A
+// processor that has stopped will not restart on its own, so the pod should
+// be restarted.
+func (c *Checker) Live(ctx context.Context) (Report, bool) {
+	report := Report{Status: "ok", Processors: make(map[string]ProcessorReport, len(c.names))}
+	ok := true
+
+	for _, name := range c.names {
+		state := c.procs[name].StateReader().State()
+		report.Processors[name] = ProcessorReport{State: stateName(state)}
+		if state == goka.ProcStateStopping || state == goka.ProcStateStopped {
+			ok = false
+		}
+	}
+
+	if !ok {
+		report.Status = "fail"
+	}
+	return report, ok
+}
+
+// Ready reports whether every processor is running with its tables fully
+// recovered, every view has recovered, and the brokers are reachable.
+func (c *Checker) Ready(ctx context.Context) (Report, bool) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{Status: "ok", Processors: make(map[string]ProcessorReport, len(c.names))}
+	ok := true
+
+	for _, name := range c.names {
+		pr, ready := checkProcessor(ctx, c.procs[name])
+		report.Processors[name] = pr
+		ok = ok && ready
+	}
+
+	if len(c.viewNames) != 0 {
+		report.Views = make(map[string]string, len(c.viewNames))
+	}
+	for _, name := range c.viewNames {
+		if c.views[name].Recovered() {
+			report.Views[name] = "recovered"
+		} else {
+			report.Views[name] = "recovering"
+			ok = false
+		}
+	}
+
+	if c.brokers != nil {
+		if err := c.brokers(ctx); err != nil {
+			report.Brokers = err.Error()
+			ok = false
+		} else {
+			report.Brokers = "ok"
+		}
+	}
+
+	if !ok {
+		report.Status = "fail"
+	}
+	return report, ok
+}
+
+func checkProcessor(ctx context.Context, p Processor) (ProcessorReport, bool) {
+	state := p.StateReader().State()
+	pr := ProcessorReport{State: stateName(state)}
+	if state != goka.ProcStateRunning {
+		return pr, false
+	}
+
+	stats := p.StatsWithContext(ctx)
+	if stats == nil {
+		return pr, false
+	}
+
+	ready := true
+	pr.Tables = make(map[string]TableReport)
+	for _, ps := range stats.Group {
+		if ps == nil {
+			continue
+		}
+
+		tables := make(map[string]*goka.TableStats, len(ps.Joined)+1)
+		for name, ts := range ps.Joined {
+			tables[name] = ts
+		}
+		if ps.TableStats != nil {
+			tables["group"] = ps.TableStats
+		}
+
+		for name, ts := range tables {
+			if ts == nil {
+				continue
+			}
+			tr := pr.Tables[name]
+			tr.Partitions++
+			if ts.Status == goka.PartitionRunning {
+				tr.Recovered++
+			} else {
+				ready = false
+			}
+			if ts.Recovery != nil && ts.Recovery.Hwm > ts.Recovery.Offset {
+				// Hwm is the next offset to be written.
+				tr.Remaining += ts.Recovery.Hwm - ts.Recovery.Offset - 1
+			}
+			pr.Tables[name] = tr
+		}
+	}
+
+	return pr, ready
+}
+
+func stateName(s goka.State) string {
+	switch s {
+	case goka.ProcStateIdle:
+		return "idle"
+	case goka.ProcStateStarting:
+		return "starting"
+	case goka.ProcStateSetup:
+		return "setup"
+	case goka.ProcStateRunning:
+		return "running"
+	case goka.ProcStateStopping:
+		return "stopping"
+	case goka.ProcStateStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("unknown(%d)", s)
+	}
+}
+
+// KafkaCheck returns a broker check that refreshes the cluster metadata using
+// a client that is created on first use and reused afterwards.
+func KafkaCheck(brokers []string, cfg *sarama.Config) func(context.Context) error {
+	var mu sync.Mutex
+	var client sarama.Client
+
+	return func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if client == nil {
+			c, err := sarama.NewClient(brokers, cfg)
+			if err != nil {
+				return err
+			}
+			client = c
+		}
+
+		return client.RefreshMetadata()
+	}
+}
@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lovoo/goka"
+)
+
+type fakeProcessor struct {
+	state *goka.Signal
+	stats *goka.ProcessorStats
+}
+
+func newFakeProcessor(state goka.State, stats *goka.ProcessorStats) *fakeProcessor {
+	s := goka.NewSignal(goka.ProcStateIdle, goka.ProcStateStarting, goka.ProcStateSetup, goka.ProcStateRunning, goka.ProcStateStopping, goka.ProcStateStopped)
+	return &fakeProcessor{state: s.SetState(state), stats: stats}
+}
+
+func (f *fakeProcessor) StateReader() goka.StateReader {
+	return f.state
+}
+
+func (f *fakeProcessor) StatsWithContext(context.Context) *goka.ProcessorStats {
+	return f.stats
+}
+
+func tableStats(status goka.PartitionStatus, offset, hwm int64) *goka.ProcessorStats {
+	return &goka.ProcessorStats{Group: map[int32]*goka.PartitionProcStats{
+		0: {Joined: map[string]*goka.TableStats{
+			"table.device.privacyfence": {Status: status, Recovery: &goka.RecoveryStats{Offset: offset, Hwm: hwm}},
+		}},
+	}}
+}
+
+func TestChecker(t *testing.T) {
+	brokersDown := func(context.Context) error { return errors.New("no brokers") }
+
+	tests := []struct {
+		name    string
+		state   goka.State
+		stats   *goka.ProcessorStats
+		brokers func(context.Context) error
+		live    bool
+		ready   bool
+	}{
+		{"Running", goka.ProcStateRunning, tableStats(goka.PartitionRunning, 99, 100), nil, true, true},
+		{"Starting", goka.ProcStateStarting, nil, nil, true, false},
+		{"Recovering", goka.ProcStateRunning, tableStats(goka.PartitionRecovering, 10, 100), nil, true, false},
+		{"BrokersDown", goka.ProcStateRunning, tableStats(goka.PartitionRunning, 99, 100), brokersDown, true, false},
+		{"Stopped", goka.ProcStateStopped, nil, nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(tt.brokers)
+			c.AddProcessor("v1", newFakeProcessor(goka.ProcStateRunning, tableStats(goka.PartitionRunning, 0, 1)))
+			c.AddProcessor("v2", newFakeProcessor(tt.state, tt.stats))
+
+			if _, live := c.Live(context.Background()); live != tt.live {
+				t.Errorf("Expected live to be %t but got %t", tt.live, live)
+			}
+			if _, ready := c.Ready(context.Background()); ready != tt.ready {
+				t.Errorf("Expected ready to be %t but got %t", tt.ready, ready)
+			}
+		})
+	}
+}
+
+type fakeView bool
+
+func (f fakeView) Recovered() bool {
+	return bool(f)
+}
+
+func TestCheckerViews(t *testing.T) {
+	c := NewChecker(nil)
+	c.AddProcessor("v1", newFakeProcessor(goka.ProcStateRunning, tableStats(goka.PartitionRunning, 0, 1)))
+	view := fakeView(false)
+	c.AddView("global", &view)
+
+	if report, ready := c.Ready(context.Background()); ready || report.Views["global"] != "recovering" {
+		t.Errorf("Expected a recovering view to fail readiness but got %+v", report)
+	}
+
+	view = true
+	if report, ready := c.Ready(context.Background()); !ready || report.Views["global"] != "recovered" {
+		t.Errorf("Expected a recovered view to pass readiness but got %+v", report)
+	}
+}
+
+func TestCheckerReportsRecovery(t *testing.T) {
+	c := NewChecker(nil)
+	c.AddProcessor("v1", newFakeProcessor(goka.ProcStateRunning, tableStats(goka.PartitionRecovering, 10, 100)))
+
+	report, _ := c.Ready(context.Background())
+
+	tr := report.Processors["v1"].Tables["table.device.privacyfence"]
+	if tr.Partitions != 1 || tr.Recovered != 0 || tr.Remaining != 89 {
+		t.Errorf("Unexpected table report %+v", tr)
+	}
+	if report.Status != "fail" {
+		t.Errorf("Expected status fail but got %s", report.Status)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	c := NewChecker(nil)
+	c.AddProcessor("v1", newFakeProcessor(goka.ProcStateSetup, nil))
+
+	app := fiber.New()
+	c.Register(app)
+
+	for path, want := range map[string]int{"/health/live": fiber.StatusOK, "/health/ready": fiber.StatusServiceUnavailable} {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Errorf("Expected %s to return %d but got %d: %s", path, want, resp.StatusCode, body)
+		}
+	}
+}
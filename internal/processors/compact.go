@@ -0,0 +1,91 @@
+package processors
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/uber/h3-go/v4"
+)
+
+// CompactFence returns a copy of data in which the cells of the fence and of
+// each of its zones are compacted, so that every complete set of children is
+// replaced by its parent (see h3.CompactCells). CellResolution is set to the
+// resolution of the original cells, so that points are redacted as before.
+//
+// The cells of a zone must be valid and, unless CellResolution is already
+// set, all of the same resolution.
+func CompactFence(data FenceData) (FenceData, error) {
+	return mapFenceCells(data, func(cells []h3.Cell, res int) ([]h3.Cell, int) {
+		return h3.CompactCells(cells), res
+	})
+}
+
+// UncompactFence undoes CompactFence, returning a copy of data in which every
+// cell has the resolution given by CellResolution, which is then cleared.
+func UncompactFence(data FenceData) (FenceData, error) {
+	return mapFenceCells(data, func(cells []h3.Cell, _ int) ([]h3.Cell, int) {
+		return cells, 0
+	})
+}
+
+// mapFenceCells replaces the cells of the fence and each zone by the result of
+// f, which receives them uncompacted to their cell resolution.
+func mapFenceCells(data FenceData, f func(cells []h3.Cell, res int) ([]h3.Cell, int)) (FenceData, error) {
+	var err error
+	data.H3Indexes, data.CellResolution, err = mapCells(data.H3Indexes, data.CellResolution, f)
+	if err != nil {
+		return FenceData{}, err
+	}
+
+	data.Zones = slices.Clone(data.Zones)
+	for i := range data.Zones {
+		z := &data.Zones[i]
+		z.H3Indexes, z.CellResolution, err = mapCells(z.H3Indexes, z.CellResolution, f)
+		if err != nil {
+			return FenceData{}, fmt.Errorf("zone %d: %w", i, err)
+		}
+	}
+
+	return data, nil
+}
+
+func mapCells(indexes []string, res int, f func(cells []h3.Cell, res int) ([]h3.Cell, int)) ([]string, int, error) {
+	if len(indexes) == 0 {
+		return indexes, res, nil
+	}
+
+	cells := make([]h3.Cell, len(indexes))
+	for i, s := range indexes {
+		cells[i] = h3.Cell(h3.IndexFromString(s))
+		if !cells[i].IsValid() {
+			return nil, 0, fmt.Errorf("invalid cell %q", s)
+		}
+	}
+
+	if res == 0 {
+		res = cells[0].Resolution()
+		for _, c := range cells {
+			if c.Resolution() != res {
+				return nil, 0, fmt.Errorf("cells have mixed resolutions and no cell resolution is set")
+			}
+		}
+	}
+	for _, c := range cells {
+		if c.Resolution() > res {
+			return nil, 0, fmt.Errorf("cell %s is finer than resolution %d", c, res)
+		}
+	}
+
+	cells = h3.UncompactCells(cells, res)
+	slices.Sort(cells)
+	cells = slices.Compact(cells)
+
+	cells, res = f(cells, res)
+	slices.Sort(cells)
+
+	out := make([]string, len(cells))
+	for i, c := range cells {
+		out[i] = c.String()
+	}
+	return out, res, nil
+}
@@ -0,0 +1,79 @@
+package processors
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/uber/h3-go/v4"
+)
+
+func TestCompactFence(t *testing.T) {
+	inside := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+	parent := h3.Cell(h3.IndexFromString("872ab259effffff"))
+
+	// Every child of parent, and one child of its neighbor.
+	var cells []string
+	for _, c := range parent.Children(8) {
+		cells = append(cells, c.String())
+	}
+	neighbor := h3.Cell(h3.IndexFromString("872ab259affffff")).Children(8)[0].String()
+	cells = append(cells, neighbor)
+	slices.Sort(cells)
+
+	data := FenceData{H3Indexes: cells, Zones: []Zone{{H3Indexes: []string{neighbor}}}}
+
+	compacted, err := CompactFence(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(compacted.H3Indexes, []string{parent.String(), neighbor}) || compacted.CellResolution != 8 {
+		t.Errorf("Unexpected compacted fence %+v", compacted)
+	}
+	if compacted.Zones[0].CellResolution != 8 || &compacted.Zones[0] == &data.Zones[0] {
+		t.Errorf("Expected the zones to be compacted in a copy but got %+v", compacted.Zones)
+	}
+
+	// Points are matched as they were before compaction.
+	f, err := NewFence(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := NewFence(compacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := f.Match(inside, time.Time{})
+	cm, cok := cf.Match(inside, time.Time{})
+	if !ok || !cok || m.Cell != cm.Cell || cm.Cell.Resolution() != 8 {
+		t.Errorf("Expected %v but got %v", m.Cell, cm.Cell)
+	}
+
+	uncompacted, err := UncompactFence(compacted)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(uncompacted.H3Indexes, cells) || uncompacted.CellResolution != 0 {
+		t.Errorf("Expected the original cells back but got %+v", uncompacted)
+	}
+}
+
+func TestCompactFenceErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data FenceData
+	}{
+		{"Invalid", FenceData{H3Indexes: []string{"notacell"}}},
+		{"Mixed", FenceData{H3Indexes: []string{"872ab259effffff", "862ab259fffffff"}}},
+		{"Finer", FenceData{H3Indexes: []string{"872ab259effffff"}, CellResolution: 6}},
+		{"Zone", FenceData{Zones: []Zone{{H3Indexes: []string{"notacell"}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompactFence(tt.data); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package processors
+
+import (
+	"fmt"
+	"math"
+)
+
+// Companion actions.
+const (
+	// CompanionDrop removes the signal.
+	CompanionDrop = "drop"
+	// CompanionCoarsen rounds a numeric signal to a multiple of Step. Other
+	// values are removed.
+	CompanionCoarsen = "coarsen"
+)
+
+// Companion is a signal, or V1 overflow key, that can reveal a position or
+// behavior inside a zone, such as hdop, altitude, heading or speed. It is
+// acted on whenever a location at the same time is redacted.
+type Companion struct {
+	Name string `json:"name"`
+	// Action is one of the Companion constants. Defaults to CompanionDrop.
+	Action string `json:"action,omitempty"`
+	// Step is the granularity used by CompanionCoarsen, in the unit of the
+	// signal.
+	Step float64 `json:"step,omitempty"`
+}
+
+// newCompanions checks a list of companions. Malformed entries are reported
+// and fall back to CompanionDrop, since dropping is always safe.
+func newCompanions(cs []Companion) ([]Companion, []error) {
+	var out []Companion
+	var errs []error
+
+	for i, c := range cs {
+		if c.Name == "" {
+			errs = append(errs, fmt.Errorf("companion %d: no name", i))
+			continue
+		}
+
+		switch c.Action {
+		case "", CompanionDrop:
+			c.Action = CompanionDrop
+		case CompanionCoarsen:
+			if !(c.Step > 0) || math.IsInf(c.Step, 0) {
+				errs = append(errs, fmt.Errorf("companion %s: invalid step %v, dropping instead", c.Name, c.Step))
+				c.Action = CompanionDrop
+			}
+		default:
+			errs = append(errs, fmt.Errorf("companion %s: unknown action %q, dropping instead", c.Name, c.Action))
+			c.Action = CompanionDrop
+		}
+
+		out = append(out, c)
+	}
+
+	return out, errs
+}
+
+// mergeCompanions returns inherited extended by own. Entries in own replace
+// inherited entries with the same name.
+func mergeCompanions(inherited, own []Companion) []Companion {
+	if len(own) == 0 {
+		return inherited
+	}
+
+	out := make([]Companion, 0, len(inherited)+len(own))
+	for _, c := range inherited {
+		if !containsCompanion(own, c.Name) {
+			out = append(out, c)
+		}
+	}
+	return append(out, own...)
+}
+
+func containsCompanion(cs []Companion, name string) bool {
+	for _, c := range cs {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the redacted value, or false if the value should be removed.
+func (c Companion) apply(v any) (any, bool) {
+	if c.Action != CompanionCoarsen {
+		return nil, false
+	}
+	f, ok := coordinate(v)
+	if !ok {
+		return nil, false
+	}
+	return math.Round(f/c.Step) * c.Step, true
+}
+
+// redactCompanionSignals applies companions to the signals within tolerance
+// milliseconds of timestamp. It returns the indexes of signals to remove.
+func redactCompanionSignals(event *StatusEventV2[StatusV2Data], companions []Companion, timestamp, tolerance int64) []int {
+	if len(companions) == 0 {
+		return nil
+	}
+
+	var drop []int
+	for i := range event.Data.Vehicle.Signals {
+		signal := &event.Data.Vehicle.Signals[i]
+		if timeDistance(signal.Timestamp, timestamp) > tolerance {
+			continue
+		}
+		for _, c := range companions {
+			if c.Name != signal.Name {
+				continue
+			}
+			if v, ok := c.apply(signal.Value); ok {
+				signal.Value = v
+			} else {
+				drop = append(drop, i)
+			}
+			break
+		}
+	}
+	return drop
+}
+
+// redactCompanionKeys applies companions to the overflow of a V1 event.
+func redactCompanionKeys(data *StatusData, companions []Companion) {
+	for _, c := range companions {
+		v, ok := data.Overflow[c.Name]
+		if !ok {
+			continue
+		}
+		if out, ok := c.apply(v); ok {
+			data.Overflow[c.Name] = out
+		} else {
+			delete(data.Overflow, c.Name)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/uber/h3-go/v4"
+)
+
+func TestCompanionApply(t *testing.T) {
+	tests := []struct {
+		companion Companion
+		value     any
+		want      any
+		kept      bool
+	}{
+		{Companion{Name: "hdop", Action: CompanionDrop}, 0.8, nil, false},
+		{Companion{Name: "altitude", Action: CompanionCoarsen, Step: 100}, 271.5, 300.0, true},
+		{Companion{Name: "heading", Action: CompanionCoarsen, Step: 45}, "100", 90.0, true},
+		{Companion{Name: "vehicleSpeed", Action: CompanionCoarsen, Step: 10}, 44, 40.0, true},
+		{Companion{Name: "cellId", Action: CompanionCoarsen, Step: 10}, "a1b2", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, kept := tt.companion.apply(tt.value)
+		if kept != tt.kept || got != tt.want {
+			t.Errorf("%+v.apply(%v) = %v, %t, want %v, %t", tt.companion, tt.value, got, kept, tt.want, tt.kept)
+		}
+	}
+}
+
+func TestNewFenceCompanions(t *testing.T) {
+	fence, err := NewFence(FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+		Companions: []Companion{
+			{Name: "hdop"},
+			{Name: "altitude", Action: CompanionCoarsen, Step: 100},
+			{Name: "heading", Action: CompanionCoarsen},
+		},
+		Zones: []Zone{{
+			Circles:    []Circle{{Latitude: 40, Longitude: -80, RadiusMeters: 100}},
+			Companions: []Companion{{Name: "altitude"}, {Name: "vehicleSpeed", Action: "blur"}},
+		}},
+	})
+	if err == nil {
+		t.Error("Expected errors for the malformed companions")
+	}
+
+	m, ok := fence.Match(h3.NewLatLng(42.26172693660968, -83.71029708818693), time.Time{})
+	if !ok {
+		t.Fatal("Expected a match in the first zone")
+	}
+	want := []Companion{
+		{Name: "hdop", Action: CompanionDrop},
+		{Name: "altitude", Action: CompanionCoarsen, Step: 100},
+		{Name: "heading", Action: CompanionDrop},
+	}
+	if !reflect.DeepEqual(m.Companions, want) {
+		t.Errorf("Expected companions %+v but got %+v", want, m.Companions)
+	}
+
+	m, ok = fence.Match(h3.NewLatLng(40, -80), time.Time{})
+	if !ok {
+		t.Fatal("Expected a match in the second zone")
+	}
+	want = []Companion{
+		{Name: "hdop", Action: CompanionDrop},
+		{Name: "heading", Action: CompanionDrop},
+		{Name: "altitude", Action: CompanionDrop},
+		{Name: "vehicleSpeed", Action: CompanionDrop},
+	}
+	if !reflect.DeepEqual(m.Companions, want) {
+		t.Errorf("Expected companions %+v but got %+v", want, m.Companions)
+	}
+}
+
+func TestSanitizeEventCompanions(t *testing.T) {
+	fence, _ := NewFence(FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+		Companions: []Companion{
+			{Name: "hdop"},
+			{Name: "altitude", Action: CompanionCoarsen, Step: 100},
+		},
+	})
+
+	event := &shared.CloudEvent[StatusData]{Data: StatusData{
+		Latitude:  ref(42.26172693660968),
+		Longitude: ref(-83.71029708818693),
+		Overflow:  map[string]any{"hdop": 0.8, "altitude": 271.5, "speed": 44.0},
+	}}
+
+	sanitizeEvent(event, fence, nil)
+
+	want := map[string]any{"altitude": 300.0, "speed": 44.0}
+	if !reflect.DeepEqual(event.Data.Overflow, want) {
+		t.Errorf("Expected overflow %v but got %v", want, event.Data.Overflow)
+	}
+
+	// Unfenced locations keep their companions.
+	event.Data = StatusData{
+		Latitude:  ref(42.261123478313145),
+		Longitude: ref(-83.68613574673722),
+		Overflow:  map[string]any{"hdop": 0.8},
+	}
+
+	sanitizeEvent(event, fence, nil)
+
+	if _, ok := event.Data.Overflow["hdop"]; !ok {
+		t.Error("Expected hdop to be kept for an unfenced location")
+	}
+}
+
+func TestSanitizeEventV2Companions(t *testing.T) {
+	fence, _ := NewFence(FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+		Companions: []Companion{
+			{Name: "hdop"},
+			{Name: "altitude", Action: CompanionCoarsen, Step: 100},
+		},
+	})
+
+	fenced := int64(1713818407248)
+	unfenced := int64(1713818400177)
+
+	event := &StatusEventV2[StatusV2Data]{CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+		Vehicle: Vehicle{Signals: []SignalData{
+			{Timestamp: fenced, Name: "latitude", Value: 42.26172693660968},
+			{Timestamp: fenced, Name: "longitude", Value: -83.71029708818693},
+			{Timestamp: fenced + 50, Name: "hdop", Value: 0.8},
+			{Timestamp: fenced, Name: "altitude", Value: 271.5},
+			{Timestamp: fenced, Name: "speed", Value: 44.0},
+			{Timestamp: unfenced, Name: "latitude", Value: 42.261123478313145},
+			{Timestamp: unfenced, Name: "longitude", Value: -83.68613574673722},
+			{Timestamp: unfenced, Name: "hdop", Value: 0.9},
+		}},
+	}}}
+
+	sanitizeEventV2(event, fence, ParentRedactor{}, locationOptions{signals: DefaultLocationSignals, tolerance: 100})
+
+	want := []SignalData{
+		{Timestamp: fenced, Name: "latitude", Value: 42.25362819577089},
+		{Timestamp: fenced, Name: "longitude", Value: -83.68562802176137},
+		{Timestamp: fenced, Name: "altitude", Value: 300.0},
+		{Timestamp: fenced, Name: "speed", Value: 44.0},
+		{Timestamp: unfenced, Name: "latitude", Value: 42.261123478313145},
+		{Timestamp: unfenced, Name: "longitude", Value: -83.68613574673722},
+		{Timestamp: unfenced, Name: "hdop", Value: 0.9},
+		{Timestamp: fenced, Name: "IsRedacted", Value: true},
+		{Timestamp: fenced, Name: "redactionSource", Value: FenceSourceVehicle},
+		{Timestamp: unfenced, Name: "IsRedacted", Value: false},
+		{Timestamp: fenced, Name: "redactedLocationCount", Value: 1.0},
+	}
+	if !reflect.DeepEqual(event.Data.Vehicle.Signals, want) {
+		t.Errorf("Expected signals %+v but got %+v", want, event.Data.Vehicle.Signals)
+	}
+}
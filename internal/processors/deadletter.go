@@ -0,0 +1,154 @@
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/rs/zerolog"
+)
+
+// Dead letter reason codes.
+const (
+	// ReasonDecodeError means the message could not be parsed at all.
+	ReasonDecodeError = "decode_error"
+	// ReasonInvalidLocation means the message parsed but carried coordinates
+	// that are out of range.
+	ReasonInvalidLocation = "invalid_location"
+)
+
+const deadLetterEventType = "zone.dimo.privacy.deadletter"
+
+// DeadLetter records a status message that could not be processed.
+type DeadLetter struct {
+	Reason    string `json:"reason"`
+	Error     string `json:"error"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Key       string `json:"key"`
+	// Payload holds the original message bytes.
+	Payload []byte `json:"payload"`
+}
+
+// inputMessage is what input codecs hand to processor callbacks. If the
+// message could not be decoded, Value is nil and Err is set.
+type inputMessage[A any] struct {
+	Value *A
+	Raw   []byte
+	Err   error
+}
+
+// inputCodec decodes JSON status messages without ever failing, so that a
+// malformed message is dead-lettered instead of stopping the processor.
+type inputCodec[A any] struct {
+	pipeline string
+}
+
+func (c inputCodec[A]) Encode(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (c inputCodec[A]) Decode(data []byte) (any, error) {
+	value := new(A)
+	if err := json.Unmarshal(data, value); err != nil {
+		decodeFailures.WithLabelValues(c.pipeline).Inc()
+		return &inputMessage[A]{Raw: data, Err: err}, nil
+	}
+	return &inputMessage[A]{Value: value, Raw: data}, nil
+}
+
+// deadLetter routes the current message to stream, or only logs it if stream
+// is empty. Either way the processor moves on to the next message.
+func deadLetter(ctx goka.Context, pipeline string, stream goka.Stream, logger *zerolog.Logger, reason string, raw []byte, err error) {
+	deadLetters.WithLabelValues(pipeline, reason).Inc()
+
+	logger.Warn().Err(err).
+		Str("key", ctx.Key()).
+		Str("reason", reason).
+		Str("topic", string(ctx.Topic())).
+		Int32("partition", ctx.Partition()).
+		Int64("offset", ctx.Offset()).
+		Msg("Could not process status message.")
+
+	if stream == "" {
+		return
+	}
+
+	emit(ctx, pipeline, stream, &shared.CloudEvent[DeadLetter]{
+		ID:          fmt.Sprintf("%s-%d-%d", ctx.Topic(), ctx.Partition(), ctx.Offset()),
+		Source:      "privacy-processor",
+		SpecVersion: "1.0",
+		Subject:     ctx.Key(),
+		Time:        time.Now(),
+		Type:        deadLetterEventType,
+		Data: DeadLetter{
+			Reason:    reason,
+			Error:     err.Error(),
+			Topic:     string(ctx.Topic()),
+			Partition: ctx.Partition(),
+			Offset:    ctx.Offset(),
+			Key:       ctx.Key(),
+			Payload:   raw,
+		},
+	})
+}
+
+// validateStatus checks the coordinates of a V1 status message.
+func validateStatus(d *StatusData) error {
+	if d.Latitude != nil {
+		if err := validateLatitude(*d.Latitude); err != nil {
+			return err
+		}
+	}
+	if d.Longitude != nil {
+		if err := validateLongitude(*d.Longitude); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateStatusV2 checks the coordinates of a V2 status message.
+func validateStatusV2(d *StatusV2Data, locations []LocationSignals) error {
+	latitudes := make(map[string]bool, len(locations))
+	longitudes := make(map[string]bool, len(locations))
+	for _, l := range locations {
+		latitudes[l.Latitude] = true
+		longitudes[l.Longitude] = true
+	}
+
+	for _, s := range d.Vehicle.Signals {
+		v, ok := coordinate(s.Value)
+		if !ok {
+			continue
+		}
+		if latitudes[s.Name] {
+			if err := validateLatitude(v); err != nil {
+				return fmt.Errorf("%s: %w", s.Name, err)
+			}
+		}
+		if longitudes[s.Name] {
+			if err := validateLongitude(v); err != nil {
+				return fmt.Errorf("%s: %w", s.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateLatitude(lat float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %v out of range", lat)
+	}
+	return nil
+}
+
+func validateLongitude(lng float64) error {
+	if lng < -180 || lng > 180 {
+		return fmt.Errorf("longitude %v out of range", lng)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+)
+
+func TestPrivacyDeadLetter(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := Privacy{
+		Group:            "privacy-processor",
+		StatusInput:      "topic.device.status",
+		FenceTable:       "table.device.privacyfence",
+		StatusOutput:     "topic.device.status.private",
+		DeadLetterOutput: "topic.device.status.deadletter",
+		Logger:           &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	dlq := gt.NewQueueTracker(string(fg.DeadLetterOutput))
+
+	deviceID := "2fbaXmHpdQiKyAH6o5hHTCYwU0U"
+	before := testutil.ToFloat64(deadLetters.WithLabelValues(PipelineV1, ReasonDecodeError))
+
+	tests := []struct {
+		name    string
+		payload string
+		reason  string
+	}{
+		{"DecodeError", `{"data":{"latitude":"north","longitude":-83.7}}`, ReasonDecodeError},
+		{"InvalidLatitude", `{"data":{"latitude":142.2,"longitude":-83.7}}`, ReasonInvalidLocation},
+		{"InvalidLongitude", `{"data":{"latitude":42.2,"longitude":-283.7}}`, ReasonInvalidLocation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gt.Consume(string(fg.StatusInput), deviceID, json.RawMessage(tt.payload))
+
+			if _, _, ok := out.Next(); ok {
+				t.Error("Expected nothing on the private output")
+			}
+
+			key, value, ok := dlq.Next()
+			if !ok {
+				t.Fatal("Expected a dead letter")
+			}
+			if key != deviceID {
+				t.Errorf("Expected key %s but got %s", deviceID, key)
+			}
+
+			dl := value.(*shared.CloudEvent[DeadLetter]).Data
+			if dl.Reason != tt.reason {
+				t.Errorf("Expected reason %s but got %s", tt.reason, dl.Reason)
+			}
+			if dl.Topic != string(fg.StatusInput) || dl.Key != deviceID || dl.Error == "" {
+				t.Errorf("Unexpected dead letter %+v", dl)
+			}
+			if string(dl.Payload) != tt.payload {
+				t.Errorf("Expected payload %s but got %s", tt.payload, dl.Payload)
+			}
+		})
+	}
+
+	if d := testutil.ToFloat64(deadLetters.WithLabelValues(PipelineV1, ReasonDecodeError)) - before; d != 1 {
+		t.Errorf("Expected 1 decode dead letter but got %f", d)
+	}
+
+	// The pipeline keeps going.
+	gt.Consume(string(fg.StatusInput), deviceID, &shared.CloudEvent[StatusData]{Data: StatusData{
+		Latitude:  ref(42.26172693660968),
+		Longitude: ref(-83.71029708818693),
+		Overflow:  map[string]any{},
+	}})
+
+	if _, _, ok := out.Next(); !ok {
+		t.Error("Expected a valid message to be processed after dead letters")
+	}
+	if _, _, ok := dlq.Next(); ok {
+		t.Error("Expected no further dead letters")
+	}
+}
+
+func TestPrivacyV2DeadLetter(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := PrivacyV2{
+		Group:            "privacy-processor-v2",
+		StatusInput:      "topic.device.status.v2",
+		FenceTable:       "table.device.privacyfence.v2",
+		StatusOutput:     "topic.device.status.private.v2",
+		DeadLetterOutput: "topic.device.status.deadletter.v2",
+		Logger:           &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	dlq := gt.NewQueueTracker(string(fg.DeadLetterOutput))
+
+	tokenID := "1234"
+
+	gt.Consume(string(fg.StatusInput), tokenID, json.RawMessage(`{"data":{"vehicle":{"signals":"none"}}}`))
+	gt.Consume(string(fg.StatusInput), tokenID, json.RawMessage(`{"data":{"vehicle":{"signals":[{"timestamp":1,"name":"latitude","value":91.5}]}}}`))
+
+	for _, reason := range []string{ReasonDecodeError, ReasonInvalidLocation} {
+		_, value, ok := dlq.Next()
+		if !ok {
+			t.Fatalf("Expected a dead letter with reason %s", reason)
+		}
+		if dl := value.(*shared.CloudEvent[DeadLetter]).Data; dl.Reason != reason {
+			t.Errorf("Expected reason %s but got %s", reason, dl.Reason)
+		}
+	}
+
+	gt.Consume(string(fg.StatusInput), tokenID, &StatusEventV2[StatusV2Data]{CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+		Vehicle: Vehicle{Signals: []SignalData{
+			{Timestamp: 1, Name: "latitude", Value: 42.2},
+			{Timestamp: 1, Name: "longitude", Value: -83.7},
+		}},
+	}}})
+
+	if _, _, ok := out.Next(); !ok {
+		t.Error("Expected a valid message to be processed after dead letters")
+	}
+}
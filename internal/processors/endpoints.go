@@ -0,0 +1,231 @@
+package processors
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/uber/h3-go/v4"
+)
+
+// defaultIgnitionSignal is used when TripEndpoints.Signal is unset.
+const defaultIgnitionSignal = "isIgnitionOn"
+
+// Defaults for following vehicles that don't report ignition by their speed.
+const (
+	defaultSpeedSignal = "speed"
+	// In km/h, above the jitter of a parked vehicle's GPS speed.
+	defaultMovingSpeed = 5
+	// Longer than most traffic lights and jams.
+	defaultParkedAfter = 5 * time.Minute
+)
+
+// defaultEndpointResolution is used when TripEndpoints.Resolution is unset.
+// Cells at resolution 7 are about 5 km² in area.
+const defaultEndpointResolution = 7
+
+// TripEndpoints configures the coarsening of locations around where trips
+// start and end, for vehicles whose FenceData asks for it. Trips start when
+// the ignition is switched on and end when it is switched off. Vehicles that
+// have never reported their ignition are followed by their speed instead: a
+// trip starts when the speed rises above MovingSpeed, and ends once it has
+// stayed at or below it, or gone unreported, for ParkedAfter.
+type TripEndpoints struct {
+	// Signal tells whether the ignition is on, as a boolean or a number that
+	// is zero when it is off. Defaults to isIgnitionOn.
+	Signal string
+	// SpeedSignal is the speed of the vehicle. Defaults to speed.
+	SpeedSignal string
+	// MovingSpeed is the speed above which the vehicle is moving, in the
+	// units of SpeedSignal. Defaults to defaultMovingSpeed.
+	MovingSpeed float64
+	// ParkedAfter is how long the vehicle must be still for a trip to end.
+	// The trip ends when it stopped, so Trajectory.Delay must be at least
+	// this long for the locations before it to be coarsened; see
+	// Trajectory.Check. Defaults to defaultParkedAfter.
+	ParkedAfter time.Duration
+	// Window is how long before a trip ends and after it starts locations
+	// are coarsened.
+	Window time.Duration
+	// Meters is how close to where a trip started or ended locations must be
+	// to be coarsened, however far apart in time they are. Zero disables the
+	// check.
+	Meters float64
+	// Resolution is the H3 resolution that locations are coarsened to.
+	// Defaults to defaultEndpointResolution.
+	Resolution int
+}
+
+func (e *TripEndpoints) signal() string {
+	if e.Signal == "" {
+		return defaultIgnitionSignal
+	}
+	return e.Signal
+}
+
+func (e *TripEndpoints) speedSignal() string {
+	if e.SpeedSignal == "" {
+		return defaultSpeedSignal
+	}
+	return e.SpeedSignal
+}
+
+func (e *TripEndpoints) movingSpeed() float64 {
+	if e.MovingSpeed == 0 {
+		return defaultMovingSpeed
+	}
+	return e.MovingSpeed
+}
+
+func (e *TripEndpoints) parkedAfter() int64 {
+	if e.ParkedAfter == 0 {
+		return defaultParkedAfter.Milliseconds()
+	}
+	return e.ParkedAfter.Milliseconds()
+}
+
+func (e *TripEndpoints) redactor() Redactor {
+	if e.Resolution == 0 {
+		return ResolutionRedactor{Resolution: defaultEndpointResolution}
+	}
+	return ResolutionRedactor{Resolution: e.Resolution}
+}
+
+// ignition converts an ignition signal value to whether the ignition is on.
+func ignition(v any) (bool, bool) {
+	if b, ok := v.(bool); ok {
+		return b, true
+	}
+	f, ok := coordinate(v)
+	return f != 0, ok
+}
+
+// observeEndpoints adds an anchor wherever the ignition of the vehicle was
+// switched on or off, or it started or stopped moving, at the location
+// nearest in time. It also remembers the ignition state, the motion and the
+// newest location for the next event.
+func (s *trajectoryState) observeEndpoints(event *StatusEventV2[StatusV2Data], e *TripEndpoints, opts locationOptions) {
+	var points []trajectoryPoint
+	if s.Last != nil {
+		points = append(points, *s.Last)
+	}
+
+	pairs, _ := pairLocations(event.Data.Vehicle.Signals, opts.signals, opts.tolerance)
+	for _, p := range pairs {
+		lat, latOK := coordinate(event.Data.Vehicle.Signals[p.lat].Value)
+		lng, lngOK := coordinate(event.Data.Vehicle.Signals[p.lng].Value)
+		if latOK && lngOK {
+			points = append(points, trajectoryPoint{
+				Timestamp: signalTime(event, p.timestamp).UnixMilli(),
+				Latitude:  lat,
+				Longitude: lng,
+			})
+		}
+	}
+
+	for _, r := range readings(event, e.signal()) {
+		on, ok := ignition(r.Value)
+		if !ok {
+			continue
+		}
+		if s.Ignition != nil && *s.Ignition != on {
+			s.addEndpoint(points, signalTime(event, r.Timestamp).UnixMilli())
+		}
+		s.Ignition = &on
+		// Ignition is more reliable than speed.
+		s.Moving, s.StoppedAt, s.MotionAt = nil, 0, 0
+	}
+
+	if s.Ignition == nil {
+		s.observeMotion(event, e, points)
+	}
+
+	for _, p := range points {
+		if s.Last == nil || p.Timestamp >= s.Last.Timestamp {
+			s.Last = &p
+		}
+	}
+}
+
+// observeMotion follows whether the vehicle is moving by its speed, and adds
+// an anchor wherever a trip started or ended.
+func (s *trajectoryState) observeMotion(event *StatusEventV2[StatusV2Data], e *TripEndpoints, points []trajectoryPoint) {
+	for _, r := range readings(event, e.speedSignal()) {
+		speed, ok := coordinate(r.Value)
+		ts := signalTime(event, r.Timestamp).UnixMilli()
+		if !ok || ts < s.MotionAt {
+			continue
+		}
+
+		if s.Moving != nil && *s.Moving {
+			// A vehicle that stops reporting has likely been switched off.
+			if s.StoppedAt == 0 && ts-s.MotionAt >= e.parkedAfter() {
+				s.StoppedAt = s.MotionAt
+			}
+			if s.StoppedAt != 0 && ts-s.StoppedAt >= e.parkedAfter() {
+				s.addEndpoint(points, s.StoppedAt)
+				s.Moving, s.StoppedAt = ref(false), 0
+			}
+		}
+
+		moving := speed > e.movingSpeed()
+		switch {
+		case s.Moving == nil:
+			s.Moving = &moving
+		case moving:
+			if !*s.Moving {
+				s.addEndpoint(points, ts)
+			}
+			s.Moving, s.StoppedAt = &moving, 0
+		case *s.Moving && s.StoppedAt == 0:
+			s.StoppedAt = ts
+		}
+		s.MotionAt = ts
+	}
+}
+
+// addEndpoint adds an anchor for a trip that started or ended at the given
+// time, at the point nearest to it.
+func (s *trajectoryState) addEndpoint(points []trajectoryPoint, timestamp int64) {
+	if p, ok := nearestPoint(points, timestamp); ok {
+		s.Anchors = append(s.Anchors, trajectoryAnchor{trajectoryPoint: trajectoryPoint{
+			Timestamp: timestamp,
+			Latitude:  p.Latitude,
+			Longitude: p.Longitude,
+		}, Endpoint: true, Source: FenceSourceTripEndpoint})
+	}
+}
+
+// readings returns the signals of event with the given name, oldest first.
+func readings(event *StatusEventV2[StatusV2Data], name string) []SignalData {
+	var out []SignalData
+	for _, sig := range event.Data.Vehicle.Signals {
+		if sig.Name == name {
+			out = append(out, sig)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b SignalData) int {
+		return cmp.Compare(a.Timestamp, b.Timestamp)
+	})
+	return out
+}
+
+func nearestPoint(points []trajectoryPoint, timestamp int64) (trajectoryPoint, bool) {
+	var best trajectoryPoint
+	found := false
+	for _, p := range points {
+		if !found || timeDistance(p.Timestamp, timestamp) < timeDistance(best.Timestamp, timestamp) {
+			best = p
+			found = true
+		}
+	}
+	return best, found
+}
+
+// coarsen returns anchor a as it applies to the location geo, which is
+// coarsened rather than snapped to where the trip started or ended.
+func (e *TripEndpoints) coarsen(a trajectoryAnchor, geo h3.LatLng) trajectoryAnchor {
+	out, _ := e.redactor().Redact(geo, Match{})
+	a.OutLatitude, a.OutLongitude = out.Lat, out.Lng
+	return a
+}
@@ -0,0 +1,201 @@
+package processors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+func ignitionEvent(timestamp int64, lat, lng float64, on bool) *StatusEventV2[StatusV2Data] {
+	event := trajectoryEvent(timestamp, lat, lng)
+	event.Data.Vehicle.Signals = append(event.Data.Vehicle.Signals, SignalData{Timestamp: timestamp, Name: "isIgnitionOn", Value: on})
+	return event
+}
+
+func TestIgnition(t *testing.T) {
+	cases := []struct {
+		value  any
+		on, ok bool
+	}{
+		{true, true, true},
+		{false, false, true},
+		{1.0, true, true},
+		{0.0, false, true},
+		{"1", true, true},
+		{"on", false, false},
+		{nil, false, false},
+	}
+
+	for _, c := range cases {
+		on, ok := ignition(c.value)
+		if on != c.on || ok != c.ok {
+			t.Errorf("ignition(%v) = %v, %v, expected %v, %v", c.value, on, ok, c.on, c.ok)
+		}
+	}
+}
+
+func TestObserveEndpoints(t *testing.T) {
+	e := &TripEndpoints{Window: time.Minute}
+	opts := locationOptions{signals: DefaultLocationSignals}
+
+	var s trajectoryState
+	s.observeEndpoints(ignitionEvent(1713818400000, 42.261123478313145, -83.68613574673722, true), e, opts)
+	if len(s.Anchors) != 0 {
+		t.Fatalf("Expected no endpoint without a previous ignition state but got %v", s.Anchors)
+	}
+
+	// The trip ends without a location in the same event.
+	s.observeEndpoints(&StatusEventV2[StatusV2Data]{CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+		Vehicle: Vehicle{Signals: []SignalData{{Timestamp: 1713818460000, Name: "isIgnitionOn", Value: 0.0}}},
+	}}}, e, opts)
+	if len(s.Anchors) != 1 {
+		t.Fatalf("Expected the trip end to be an anchor but got %v", s.Anchors)
+	}
+	if a := s.Anchors[0]; !a.Endpoint || a.Timestamp != 1713818460000 || a.Latitude != 42.261123478313145 {
+		t.Errorf("Expected the trip end at the last known location but got %+v", a)
+	}
+
+	s.observeEndpoints(ignitionEvent(1713822000000, 42.26172693660968, -83.71029708818693, true), e, opts)
+	if len(s.Anchors) != 2 || s.Anchors[1].Latitude != 42.26172693660968 {
+		t.Errorf("Expected the trip start to be an anchor but got %v", s.Anchors)
+	}
+}
+
+func speedEvent(timestamp int64, lat, lng, speed float64) *StatusEventV2[StatusV2Data] {
+	event := trajectoryEvent(timestamp, lat, lng)
+	event.Data.Vehicle.Signals = append(event.Data.Vehicle.Signals, SignalData{Timestamp: timestamp, Name: "speed", Value: speed})
+	return event
+}
+
+func TestObserveEndpointsBySpeed(t *testing.T) {
+	e := &TripEndpoints{Window: time.Minute}
+	opts := locationOptions{signals: DefaultLocationSignals}
+	minute := time.Minute.Milliseconds()
+
+	const (
+		t0         = 1713818400000
+		parkedLat  = 42.261123478313145
+		parkedLng  = -83.68613574673722
+		drivingLat = 42.26172693660968
+		drivingLng = -83.71029708818693
+	)
+
+	var s trajectoryState
+	for _, event := range []*StatusEventV2[StatusV2Data]{
+		speedEvent(t0, drivingLat, drivingLng, 50),
+		// A traffic light.
+		speedEvent(t0+minute, drivingLat, drivingLng, 0),
+		speedEvent(t0+2*minute, drivingLat, drivingLng, 30),
+		speedEvent(t0+3*minute, parkedLat, parkedLng, 0),
+		speedEvent(t0+4*minute, parkedLat, parkedLng, 1),
+	} {
+		s.observeEndpoints(event, e, opts)
+	}
+	if len(s.Anchors) != 0 {
+		t.Fatalf("Expected no endpoint before the vehicle has been still for long but got %v", s.Anchors)
+	}
+
+	s.observeEndpoints(speedEvent(t0+9*minute, parkedLat, parkedLng, 0), e, opts)
+	if len(s.Anchors) != 1 {
+		t.Fatalf("Expected the trip end to be an anchor but got %v", s.Anchors)
+	}
+	if a := s.Anchors[0]; !a.Endpoint || a.Timestamp != t0+3*minute || a.Latitude != parkedLat {
+		t.Errorf("Expected the trip to end where the vehicle stopped but got %+v", a)
+	}
+
+	s.observeEndpoints(speedEvent(t0+60*minute, parkedLat, parkedLng, 40), e, opts)
+	if len(s.Anchors) != 2 || s.Anchors[1].Timestamp != t0+60*minute {
+		t.Fatalf("Expected the trip start to be an anchor but got %v", s.Anchors)
+	}
+
+	// The vehicle stops reporting while moving, and reports again later.
+	s.observeEndpoints(speedEvent(t0+120*minute, drivingLat, drivingLng, 40), e, opts)
+	if len(s.Anchors) != 4 || s.Anchors[2].Timestamp != t0+60*minute || s.Anchors[3].Timestamp != t0+120*minute {
+		t.Errorf("Expected a gap in reports to end a trip and start another but got %v", s.Anchors)
+	}
+
+	// Ignition takes over from speed.
+	s.observeEndpoints(ignitionEvent(t0+121*minute, drivingLat, drivingLng, true), e, opts)
+	if s.Moving != nil {
+		t.Errorf("Expected motion to be forgotten once ignition is reported but got %v", *s.Moving)
+	}
+}
+
+func TestPrivacyV2TripEndpoints(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	// Endpoints are still found when the ignition isn't published.
+	filter, err := ParseFilter("isIgnitionOn", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Trajectory: &Trajectory{
+			Delay:         2 * time.Minute,
+			TripEndpoints: &TripEndpoints{Window: time.Minute},
+		},
+		Filter: filter,
+		Logger: &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+
+	optedIn, optedOut := "3333", "4444"
+
+	gt.SetTableValue(fg.FenceTable, optedIn, &shared.CloudEvent[FenceData]{Data: FenceData{TripEndpoints: true}})
+
+	const t0 = 1713818400000
+	for _, id := range []string{optedIn, optedOut} {
+		gt.Consume(string(fg.StatusInput), id, ignitionEvent(t0, 42.261123478313145, -83.68613574673722, true))
+		gt.Consume(string(fg.StatusInput), id, ignitionEvent(t0+30_000, 42.26172693660968, -83.71029708818693, false))
+		gt.Consume(string(fg.StatusInput), id, ignitionEvent(t0+600_000, 42.26172693660968, -83.71029708818693, false))
+	}
+
+	coarse := func(lat, lng float64) h3.LatLng {
+		return h3.LatLngToCell(h3.NewLatLng(lat, lng), defaultEndpointResolution).LatLng()
+	}
+
+	for _, expected := range []struct {
+		key      string
+		lat, lng float64
+	}{
+		{optedIn, coarse(42.261123478313145, -83.68613574673722).Lat, coarse(42.261123478313145, -83.68613574673722).Lng},
+		{optedIn, coarse(42.26172693660968, -83.71029708818693).Lat, coarse(42.26172693660968, -83.71029708818693).Lng},
+		{optedOut, 42.261123478313145, -83.68613574673722},
+		{optedOut, 42.26172693660968, -83.71029708818693},
+	} {
+		key, value, ok := out.Next()
+		if !ok {
+			t.Fatal("Expected the first two events of each vehicle to be released")
+		}
+		signals := value.(*StatusEventV2[StatusV2Data]).Data.Vehicle.Signals
+		if key != expected.key || signals[0].Value != expected.lat || signals[1].Value != expected.lng {
+			t.Errorf("Expected %s at %v, %v but got %s at %v, %v", expected.key, expected.lat, expected.lng, key, signals[0].Value, signals[1].Value)
+		}
+		for _, s := range signals {
+			if s.Name == "isIgnitionOn" {
+				t.Errorf("Expected the ignition to be filtered out but got %+v", s)
+			}
+		}
+	}
+
+	if _, _, ok := out.Next(); ok {
+		t.Error("Expected the last event of each vehicle to be held back")
+	}
+}
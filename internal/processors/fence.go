@@ -0,0 +1,536 @@
+package processors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/uber/h3-go/v4"
+)
+
+// defaultShapeResolution is the H3 resolution used to index points matched by
+// polygons and circles when the fence does not specify one. It matches the
+// resolution of the cells the app has historically written.
+const defaultShapeResolution = 7
+
+// Geometry is a GeoJSON geometry object. Only the Polygon and MultiPolygon
+// types are supported.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// Circle is a disc of the given radius around a center point.
+type Circle struct {
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	RadiusMeters float64 `json:"radiusMeters"`
+}
+
+// Zone is a privacy zone with its own shapes and, optionally, schedules. The
+// shape fields have the same meaning as in FenceData.
+type Zone struct {
+	H3Indexes      []string   `json:"h3Indexes,omitempty"`
+	CellResolution int        `json:"cellResolution,omitempty"`
+	Geometries     []Geometry `json:"geometries,omitempty"`
+	Circles        []Circle   `json:"circles,omitempty"`
+	Resolution     int        `json:"resolution,omitempty"`
+	// Schedules restrict the zone to certain times. A zone with no schedules
+	// is always active; otherwise it is active whenever any schedule is.
+	Schedules []Schedule `json:"schedules,omitempty"`
+	// Redaction overrides the pipeline's default redaction for this zone.
+	Redaction *Redaction `json:"redaction,omitempty"`
+	// Companions are acted on in addition to those of the fence. An entry
+	// here replaces a fence entry with the same name.
+	Companions []Companion `json:"companions,omitempty"`
+}
+
+// Match describes how a point fell in a fence.
+type Match struct {
+	// Cell contains the point and has the resolution of the matching fence
+	// cell, or the zone resolution if the point was matched by a polygon or
+	// circle.
+	Cell h3.Cell
+	// Centroid is the center of the matching zone.
+	Centroid h3.LatLng
+	// Redactor is the zone's redactor, or nil if the zone uses the pipeline
+	// default.
+	Redactor Redactor
+	// Companions are the zone's companion signals.
+	Companions []Companion
+	// Source tells where the matching zone came from. It is one of the
+	// FenceSource constants.
+	Source string
+
+	// maxRes, if set, is the finest resolution the point may be reported at,
+	// whatever the zone's redaction.
+	maxRes *int
+}
+
+// Fence sources, recorded in outputs to tell what caused a redaction.
+const (
+	// FenceSourceVehicle is the vehicle's own fence.
+	FenceSourceVehicle = "vehicle"
+	// FenceSourceGlobal is the set of fences that apply to every vehicle.
+	FenceSourceGlobal = "global"
+	// FenceSourcePolicy is an output policy that redacts every location.
+	FenceSourcePolicy = "policy"
+	// FenceSourceTripEndpoint is the start or end of a trip.
+	FenceSourceTripEndpoint = "tripEndpoint"
+)
+
+// Fence is the normalized form of FenceData that both pipelines match points
+// against.
+type Fence struct {
+	zones []*zone
+	// companions apply to every zone.
+	companions []Companion
+	// tripEndpoints is set if trip endpoints should be obfuscated.
+	tripEndpoints bool
+	// global is matched after the zones above.
+	global *Fence
+	// fallback, if set, is matched last and matches every point.
+	fallback *zone
+	// index, if set, narrows down the zones that may contain a point.
+	index *zoneIndex
+	// maxRes, if set, bounds the precision of every redacted point.
+	maxRes *int
+}
+
+type zone struct {
+	cells []h3.Cell
+	// byRes holds the same cells grouped by resolution, in the order in which
+	// each resolution first appears in cells.
+	byRes []cellGroup
+	// cellRes, if set, is the resolution that the cells were compacted from.
+	cellRes   int
+	polygons  []polygon
+	circles   []Circle
+	res       int
+	schedules []*schedule
+	centroid  h3.LatLng
+	redactor  Redactor
+	// companions include those inherited from the fence.
+	companions []Companion
+	// everywhere zones match every point.
+	everywhere bool
+	// source is one of the FenceSource constants.
+	source string
+}
+
+// cellGroup is a set of cells of the same resolution.
+type cellGroup struct {
+	res   int
+	cells map[h3.Cell]struct{}
+}
+
+// groupCells groups cells by resolution, so that matching a point computes
+// one cell for each resolution rather than one for each cell.
+func groupCells(cells []h3.Cell) []cellGroup {
+	var groups []cellGroup
+	for _, c := range cells {
+		i := slices.IndexFunc(groups, func(g cellGroup) bool { return g.res == c.Resolution() })
+		if i < 0 {
+			i = len(groups)
+			groups = append(groups, cellGroup{res: c.Resolution(), cells: make(map[h3.Cell]struct{})})
+		}
+		groups[i].cells[c] = struct{}{}
+	}
+	return groups
+}
+
+// polygon is a list of rings in which the first ring is the exterior and the
+// rest are holes.
+type polygon [][]h3.LatLng
+
+// NewFence builds a matcher from the given fence data. Malformed shapes and
+// schedules are skipped and reported in the returned error, but the rest of
+// the fence is still returned so that a single bad entry does not disable the
+// whole fence. A zone whose schedules are all malformed stays always active,
+// one with a malformed redaction uses the pipeline default, and malformed
+// companions are dropped.
+func NewFence(data FenceData) (*Fence, error) {
+	zones := append([]Zone{{
+		H3Indexes:      data.H3Indexes,
+		CellResolution: data.CellResolution,
+		Geometries:     data.Geometries,
+		Circles:        data.Circles,
+		Resolution:     data.Resolution,
+		Schedules:      data.Schedules,
+		Redaction:      data.Redaction,
+	}}, data.Zones...)
+
+	f := &Fence{zones: make([]*zone, len(zones)), tripEndpoints: data.TripEndpoints}
+
+	var errs []error
+	f.companions, errs = newCompanions(data.Companions)
+
+	for i, z := range zones {
+		var zerrs []error
+		f.zones[i], zerrs = newZone(z)
+		f.zones[i].source = FenceSourceVehicle
+		f.zones[i].companions = mergeCompanions(f.companions, f.zones[i].companions)
+		for _, err := range zerrs {
+			if i == 0 {
+				errs = append(errs, err)
+			} else {
+				errs = append(errs, fmt.Errorf("zone %d: %w", i-1, err))
+			}
+		}
+	}
+
+	return f, errors.Join(errs...)
+}
+
+func newZone(data Zone) (*zone, []error) {
+	z := &zone{
+		cells:   make([]h3.Cell, 0, len(data.H3Indexes)),
+		circles: data.Circles,
+		res:     data.Resolution,
+	}
+
+	var errs []error
+
+	if z.res < 0 || z.res > 15 {
+		errs = append(errs, fmt.Errorf("resolution %d out of range", z.res))
+		z.res = 0
+	}
+	if z.res == 0 {
+		z.res = defaultShapeResolution
+	}
+
+	if data.CellResolution < 0 || data.CellResolution > 15 {
+		errs = append(errs, fmt.Errorf("cell resolution %d out of range", data.CellResolution))
+	} else {
+		z.cellRes = data.CellResolution
+	}
+
+	for _, s := range data.H3Indexes {
+		c := h3.Cell(h3.IndexFromString(s))
+		if !c.IsValid() {
+			errs = append(errs, fmt.Errorf("invalid cell %q", s))
+			continue
+		}
+		if z.cellRes != 0 && c.Resolution() > z.cellRes {
+			errs = append(errs, fmt.Errorf("cell %s is finer than the cell resolution %d", s, z.cellRes))
+			continue
+		}
+		z.cells = append(z.cells, c)
+	}
+	z.byRes = groupCells(z.cells)
+
+	for i, g := range data.Geometries {
+		polys, err := parseGeometry(g)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("geometry %d: %w", i, err))
+			continue
+		}
+		z.polygons = append(z.polygons, polys...)
+	}
+
+	for i, sd := range data.Schedules {
+		sc, err := newSchedule(sd)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule %d: %w", i, err))
+			continue
+		}
+		z.schedules = append(z.schedules, sc)
+	}
+
+	var cerrs []error
+	z.companions, cerrs = newCompanions(data.Companions)
+	errs = append(errs, cerrs...)
+
+	if data.Redaction != nil {
+		r, err := NewRedactor(*data.Redaction)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("redaction: %w", err))
+		} else {
+			z.redactor = r
+		}
+	}
+
+	z.centroid = z.computeCentroid()
+
+	return z, errs
+}
+
+// computeCentroid averages the centers of the zone's cells and circles and the
+// exterior vertices of its polygons. This is a representative point rather
+// than a true centroid, which is all redaction needs.
+func (z *zone) computeCentroid() h3.LatLng {
+	var lat, lng float64
+	var n int
+
+	for _, c := range z.cells {
+		geo := c.LatLng()
+		lat, lng, n = lat+geo.Lat, lng+geo.Lng, n+1
+	}
+	for _, p := range z.polygons {
+		// The closing position repeats the first one.
+		for _, geo := range p[0][:len(p[0])-1] {
+			lat, lng, n = lat+geo.Lat, lng+geo.Lng, n+1
+		}
+	}
+	for _, c := range z.circles {
+		lat, lng, n = lat+c.Latitude, lng+c.Longitude, n+1
+	}
+
+	if n == 0 {
+		return h3.LatLng{}
+	}
+	return h3.NewLatLng(lat/float64(n), lng/float64(n))
+}
+
+// Empty reports whether the fence, including any global fences it was
+// combined with, contains no shapes at all.
+func (f *Fence) Empty() bool {
+	if f == nil {
+		return true
+	}
+	if f.fallback != nil {
+		return false
+	}
+	for _, z := range f.zones {
+		if z.everywhere || len(z.cells) != 0 || len(z.polygons) != 0 || len(z.circles) != 0 {
+			return false
+		}
+	}
+	return f.global.Empty()
+}
+
+// obfuscatesTripEndpoints reports whether the vehicle asked for its trip
+// endpoints to be obfuscated.
+func (f *Fence) obfuscatesTripEndpoints() bool {
+	return f != nil && f.tripEndpoints
+}
+
+// size is the number of cells, polygons and circles in the fence.
+func (f *Fence) size() int {
+	if f == nil {
+		return 0
+	}
+	n := 0
+	for _, z := range f.zones {
+		n += len(z.cells) + len(z.polygons) + len(z.circles)
+	}
+	return n
+}
+
+// orElse returns a fence that matches whatever f matches, and every other
+// point as well, redacting the latter with r.
+func (f *Fence) orElse(r Redactor) *Fence {
+	out := &Fence{}
+	if f != nil {
+		*out = *f
+	}
+	out.fallback = &zone{
+		res:        defaultShapeResolution,
+		redactor:   r,
+		companions: out.companions,
+		everywhere: true,
+		source:     FenceSourcePolicy,
+	}
+	return out
+}
+
+// coarsenedTo returns a fence that matches whatever f matches, but never
+// redacts a point to a resolution finer than res.
+func (f *Fence) coarsenedTo(res int) *Fence {
+	out := &Fence{}
+	if f != nil {
+		*out = *f
+	}
+	out.maxRes = &res
+	return out
+}
+
+// withGlobal returns a fence that matches whatever f matches, and then
+// whatever global matches.
+func (f *Fence) withGlobal(global *Fence) *Fence {
+	if global == nil {
+		return f
+	}
+	out := &Fence{}
+	if f != nil {
+		*out = *f
+	}
+	out.global = global
+	return out
+}
+
+// Match reports whether geo lies inside a zone of the fence that is active at
+// time t, and if so, describes the match.
+//
+// A zero t means the time of the reading is unknown, in which case scheduled
+// zones are treated as active.
+func (f *Fence) Match(geo h3.LatLng, t time.Time) (Match, bool) {
+	if f == nil {
+		return Match{}, false
+	}
+	m, ok := f.match(geo, t)
+	if ok && f.maxRes != nil {
+		m.maxRes = f.maxRes
+	}
+	return m, ok
+}
+
+func (f *Fence) match(geo h3.LatLng, t time.Time) (Match, bool) {
+
+	zones := f.zones
+	if f.index != nil {
+		zones = f.index.candidates(zones, geo)
+	}
+	for _, z := range zones {
+		if m, ok := z.matchAt(geo, t); ok {
+			return m, true
+		}
+	}
+
+	if m, ok := f.global.Match(geo, t); ok {
+		return m, true
+	}
+
+	if f.fallback != nil {
+		return f.fallback.matchAt(geo, t)
+	}
+
+	return Match{}, false
+}
+
+func (z *zone) matchAt(geo h3.LatLng, t time.Time) (Match, bool) {
+	if !z.activeAt(t) {
+		return Match{}, false
+	}
+	cell, ok := z.match(geo)
+	if !ok {
+		return Match{}, false
+	}
+	return Match{Cell: cell, Centroid: z.centroid, Redactor: z.redactor, Companions: z.companions, Source: z.source}, true
+}
+
+func (z *zone) activeAt(t time.Time) bool {
+	if len(z.schedules) == 0 || t.IsZero() {
+		return true
+	}
+	for _, sc := range z.schedules {
+		if sc.activeAt(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *zone) match(geo h3.LatLng) (h3.Cell, bool) {
+	if z.everywhere {
+		return h3.LatLngToCell(geo, z.res), true
+	}
+
+	for _, g := range z.byRes {
+		cell := h3.LatLngToCell(geo, g.res)
+		if _, ok := g.cells[cell]; ok {
+			if z.cellRes > g.res {
+				// The cell was compacted.
+				cell = h3.LatLngToCell(geo, z.cellRes)
+			}
+			return cell, true
+		}
+	}
+
+	for _, p := range z.polygons {
+		if p.contains(geo) {
+			return h3.LatLngToCell(geo, z.res), true
+		}
+	}
+
+	for _, c := range z.circles {
+		if h3.GreatCircleDistanceM(geo, h3.NewLatLng(c.Latitude, c.Longitude)) <= c.RadiusMeters {
+			return h3.LatLngToCell(geo, z.res), true
+		}
+	}
+
+	return 0, false
+}
+
+func parseGeometry(g Geometry) ([]polygon, error) {
+	switch g.Type {
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, err
+		}
+		p, err := newPolygon(coords)
+		if err != nil {
+			return nil, err
+		}
+		return []polygon{p}, nil
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, err
+		}
+		out := make([]polygon, len(coords))
+		for i, c := range coords {
+			p, err := newPolygon(c)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = p
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+}
+
+// newPolygon converts GeoJSON polygon coordinates, which are in longitude,
+// latitude order, into a polygon.
+func newPolygon(coords [][][]float64) (polygon, error) {
+	if len(coords) == 0 {
+		return nil, fmt.Errorf("polygon has no rings")
+	}
+
+	p := make(polygon, len(coords))
+	for i, ring := range coords {
+		if len(ring) < 4 {
+			return nil, fmt.Errorf("ring %d has %d positions, need at least 4", i, len(ring))
+		}
+		p[i] = make([]h3.LatLng, len(ring))
+		for j, pos := range ring {
+			if len(pos) < 2 {
+				return nil, fmt.Errorf("ring %d position %d has %d coordinates", i, j, len(pos))
+			}
+			p[i][j] = h3.NewLatLng(pos[1], pos[0])
+		}
+	}
+
+	return p, nil
+}
+
+func (p polygon) contains(geo h3.LatLng) bool {
+	if !ringContains(p[0], geo) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, geo) {
+			return false
+		}
+	}
+	return true
+}
+
+// ringContains is a planar ray casting test. Zones are small enough that
+// treating latitude and longitude as plane coordinates is accurate.
+func ringContains(ring []h3.LatLng, geo h3.LatLng) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > geo.Lat) != (b.Lat > geo.Lat) &&
+			geo.Lng < (b.Lng-a.Lng)*(geo.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			in = !in
+		}
+	}
+	return in
+}
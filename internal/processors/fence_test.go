@@ -0,0 +1,133 @@
+package processors
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/uber/h3-go/v4"
+)
+
+func TestFenceMatch(t *testing.T) {
+	inside := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+	outside := h3.NewLatLng(42.261123478313145, -83.68613574673722)
+
+	square := Geometry{
+		Type:        "Polygon",
+		Coordinates: json.RawMessage(`[[[-83.72, 42.25], [-83.70, 42.25], [-83.70, 42.27], [-83.72, 42.27], [-83.72, 42.25]]]`),
+	}
+	squareWithHole := Geometry{
+		Type: "Polygon",
+		Coordinates: json.RawMessage(`[
+			[[-83.72, 42.25], [-83.70, 42.25], [-83.70, 42.27], [-83.72, 42.27], [-83.72, 42.25]],
+			[[-83.711, 42.261], [-83.709, 42.261], [-83.709, 42.262], [-83.711, 42.262], [-83.711, 42.261]]
+		]`),
+	}
+	multi := Geometry{
+		Type: "MultiPolygon",
+		Coordinates: json.RawMessage(`[
+			[[[0, 0], [1, 0], [1, 1], [0, 1], [0, 0]]],
+			[[[-83.72, 42.25], [-83.70, 42.25], [-83.70, 42.27], [-83.72, 42.27], [-83.72, 42.25]]]
+		]`),
+	}
+
+	tests := []struct {
+		name    string
+		fence   FenceData
+		geo     h3.LatLng
+		match   bool
+		wantRes int
+	}{
+		{"Cell", FenceData{H3Indexes: []string{"872ab259effffff"}}, inside, true, 7},
+		{"CellMiss", FenceData{H3Indexes: []string{"872ab259effffff"}}, outside, false, 0},
+		{"Polygon", FenceData{Geometries: []Geometry{square}}, inside, true, 7},
+		{"PolygonMiss", FenceData{Geometries: []Geometry{square}}, outside, false, 0},
+		{"PolygonHole", FenceData{Geometries: []Geometry{squareWithHole}}, inside, false, 0},
+		{"MultiPolygon", FenceData{Geometries: []Geometry{multi}}, inside, true, 7},
+		{"PolygonResolution", FenceData{Geometries: []Geometry{square}, Resolution: 9}, inside, true, 9},
+		{"Circle", FenceData{Circles: []Circle{{Latitude: 42.2617, Longitude: -83.7103, RadiusMeters: 100}}}, inside, true, 7},
+		{"CircleMiss", FenceData{Circles: []Circle{{Latitude: 42.2617, Longitude: -83.7103, RadiusMeters: 100}}}, outside, false, 0},
+		{"Empty", FenceData{}, inside, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFence(tt.fence)
+			if err != nil {
+				t.Fatalf("Unexpected error building fence: %v", err)
+			}
+
+			m, ok := f.Match(tt.geo, time.Time{})
+			if ok != tt.match {
+				t.Fatalf("Expected match to be %t but got %t", tt.match, ok)
+			}
+			if ok && m.Cell.Resolution() != tt.wantRes {
+				t.Errorf("Expected matched cell at resolution %d but got %d", tt.wantRes, m.Cell.Resolution())
+			}
+		})
+	}
+}
+
+func TestNewFenceInvalidGeometry(t *testing.T) {
+	f, err := NewFence(FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+		Geometries: []Geometry{
+			{Type: "Point", Coordinates: json.RawMessage(`[0, 0]`)},
+			{Type: "Polygon", Coordinates: json.RawMessage(`[[[0, 0], [1, 1]]]`)},
+		},
+	})
+	if err == nil {
+		t.Error("Expected an error for unsupported and degenerate geometries")
+	}
+
+	if _, ok := f.Match(h3.NewLatLng(42.26172693660968, -83.71029708818693), time.Time{}); !ok {
+		t.Error("Expected the valid part of the fence to still match")
+	}
+}
+
+func TestNilFence(t *testing.T) {
+	var f *Fence
+	if !f.Empty() {
+		t.Error("Expected nil fence to be empty")
+	}
+	if _, ok := f.Match(h3.NewLatLng(0, 0), time.Time{}); ok {
+		t.Error("Expected nil fence to match nothing")
+	}
+}
+
+// benchmarkCells returns n distinct resolution 9 cells around Ann Arbor.
+func benchmarkCells(n int) []string {
+	origin := h3.LatLngToCell(h3.NewLatLng(42.26172693660968, -83.71029708818693), 9)
+	k := 0
+	for 1+3*k*(k+1) < n {
+		k++
+	}
+
+	cells := make([]string, n)
+	for i, c := range origin.GridDisk(k)[:n] {
+		cells[i] = c.String()
+	}
+	return cells
+}
+
+func BenchmarkFenceMatch(b *testing.B) {
+	// Outside every fence, so that every cell is considered.
+	geo := h3.NewLatLng(48.05, 2.05)
+
+	for _, n := range []int{1, 100, 10000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			f, err := NewFence(FenceData{H3Indexes: benchmarkCells(n)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+
+			for range b.N {
+				if _, ok := f.Match(geo, time.Time{}); ok {
+					b.Fatal("Unexpected match")
+				}
+			}
+		})
+	}
+}
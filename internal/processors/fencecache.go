@@ -0,0 +1,151 @@
+package processors
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+)
+
+// fenceCacheSize bounds the parsed fences kept for each partition. The least
+// recently used fence is forgotten to make room for a new one.
+const fenceCacheSize = 10000
+
+// fenceCache keeps parsed fences so that a fence is only parsed again when it
+// changes. Each partition has its own cache, so partitions that are processed
+// concurrently do not wait on each other. Fences are validated when they are
+// parsed, and invalid ones are reported once for each version. A nil
+// *fenceCache parses and validates every fence with the default limits, and
+// reports nothing.
+//
+// A fence is cached under its table and key, together with the ID and time of
+// the event that carries it, which serve as its version. Past versions kept by
+// a FenceHistory are cached under their ID as well, apart from the current
+// one. Fences without an ID are never cached. Cached fences are shared
+// between messages and must not be modified.
+type fenceCache struct {
+	pipeline string
+	limits   FenceLimits
+	// reports receives reports of invalid fences. If empty, they are only
+	// counted.
+	reports goka.Stream
+
+	mu         sync.Mutex
+	partitions map[int32]*partitionFences
+}
+
+type partitionFences struct {
+	mu      sync.Mutex
+	entries map[fenceKey]*list.Element
+	// order holds *cachedFence values, the most recently used first.
+	order *list.List
+}
+
+type fenceKey struct {
+	table goka.Table
+	key   string
+	// past is the ID of a past version, or empty for the current one.
+	past string
+}
+
+type cachedFence struct {
+	key fenceKey
+	// id and time are the version of the fence.
+	id    string
+	time  time.Time
+	fence *Fence
+	err   error
+}
+
+func newFenceCache(pipeline string, limits FenceLimits, reports goka.Stream) *fenceCache {
+	return &fenceCache{pipeline: pipeline, limits: limits, reports: reports, partitions: make(map[int32]*partitionFences)}
+}
+
+// readFence returns the parsed form of event, the fence stored under key in
+// table, and reports it if it is newly found to be invalid. See NewFence for
+// the error semantics.
+func readFence(ctx goka.Context, c *fenceCache, table goka.Table, key string, event *shared.CloudEvent[FenceData]) (*Fence, error) {
+	f, parsed, err := c.fence(ctx.Partition(), table, key, event)
+	if c != nil && parsed && err != nil {
+		reportFence(ctx, c.pipeline, c.reports, table, key, event, err)
+	}
+	return f, err
+}
+
+// fence returns the parsed form of event, and whether it had to be parsed
+// rather than found in the cache.
+func (c *fenceCache) fence(partition int32, table goka.Table, key string, event *shared.CloudEvent[FenceData]) (*Fence, bool, error) {
+	return c.lookup(partition, fenceKey{table: table, key: key}, event)
+}
+
+// pastFence returns the parsed form of v, a past version of the fence stored
+// under key in table. Its errors were reported when it was current.
+func (c *fenceCache) pastFence(partition int32, table goka.Table, key string, v fenceVersion) (*Fence, error) {
+	event := &shared.CloudEvent[FenceData]{ID: v.ID, Data: v.Data}
+	f, _, err := c.lookup(partition, fenceKey{table: table, key: key, past: v.ID}, event)
+	return f, err
+}
+
+func (c *fenceCache) lookup(partition int32, k fenceKey, event *shared.CloudEvent[FenceData]) (*Fence, bool, error) {
+	if c == nil || event.ID == "" {
+		f, err := c.parse(event.Data)
+		return f, true, err
+	}
+
+	p := c.partition(partition)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.entries[k]; ok {
+		p.order.MoveToFront(e)
+		cf := e.Value.(*cachedFence)
+		if cf.id == event.ID && cf.time.Equal(event.Time) {
+			fenceCacheLookups.WithLabelValues(c.pipeline, "hit").Inc()
+			return cf.fence, false, cf.err
+		}
+		// The fence has changed.
+		fenceCacheLookups.WithLabelValues(c.pipeline, "stale").Inc()
+		cf.id, cf.time = event.ID, event.Time
+		cf.fence, cf.err = c.parse(event.Data)
+		return cf.fence, true, cf.err
+	}
+	fenceCacheLookups.WithLabelValues(c.pipeline, "miss").Inc()
+
+	cf := &cachedFence{key: k, id: event.ID, time: event.Time}
+	cf.fence, cf.err = c.parse(event.Data)
+
+	if p.order.Len() >= fenceCacheSize {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.entries, oldest.Value.(*cachedFence).key)
+	}
+	p.entries[k] = p.order.PushFront(cf)
+
+	return cf.fence, true, cf.err
+}
+
+// parse builds the fence and checks it against the limits.
+func (c *fenceCache) parse(data FenceData) (*Fence, error) {
+	var limits FenceLimits
+	if c != nil {
+		limits = c.limits
+	}
+
+	f, err := NewFence(data)
+	return f, errors.Join(err, limits.check(f))
+}
+
+func (c *fenceCache) partition(partition int32) *partitionFences {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.partitions[partition]
+	if !ok {
+		p = &partitionFences{entries: make(map[fenceKey]*list.Element), order: list.New()}
+		c.partitions[partition] = p
+	}
+	return p
+}
@@ -0,0 +1,81 @@
+package processors
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+)
+
+func TestFenceCache(t *testing.T) {
+	c := newFenceCache(PipelineV1, FenceLimits{}, "")
+	event := &shared.CloudEvent[FenceData]{ID: "1", Data: FenceData{H3Indexes: []string{"872ab259effffff"}}}
+
+	f1, parsed, _ := c.fence(0, "table", "3333", event)
+	if !parsed {
+		t.Error("Expected the fence to be parsed")
+	}
+	f2, parsed, _ := c.fence(0, "table", "3333", event)
+	if f1 != f2 || parsed {
+		t.Error("Expected the parsed fence to be reused")
+	}
+
+	if f, _, _ := c.fence(1, "table", "3333", event); f == f1 {
+		t.Error("Expected each partition to parse its own fence")
+	}
+
+	changed := &shared.CloudEvent[FenceData]{ID: "1", Time: time.UnixMilli(1), Data: FenceData{H3Indexes: []string{"872ab259affffff"}}}
+	if f, _, _ := c.fence(0, "table", "3333", changed); f == f1 || f.zones[0].cells[0].String() != "872ab259affffff" {
+		t.Errorf("Expected a new version of the fence to be parsed but got %+v", f.zones[0])
+	}
+
+	unversioned := &shared.CloudEvent[FenceData]{Data: event.Data}
+	f3, _, _ := c.fence(0, "table", "4444", unversioned)
+	f4, _, _ := c.fence(0, "table", "4444", unversioned)
+	if f3 == f4 {
+		t.Error("Expected a fence without an ID not to be cached")
+	}
+
+	for i := range fenceCacheSize + 1 {
+		c.fence(2, "table", strconv.Itoa(i), event) //nolint
+	}
+	if p := c.partition(2); len(p.entries) != fenceCacheSize || p.entries[fenceKey{table: "table", key: "0"}] != nil {
+		t.Errorf("Expected the least recently used fence to be forgotten, %d left", len(p.entries))
+	}
+}
+
+func TestFenceCachePastVersions(t *testing.T) {
+	c := newFenceCache(PipelineV1, FenceLimits{}, "")
+	current := &shared.CloudEvent[FenceData]{ID: "2", Data: FenceData{H3Indexes: []string{"872ab259affffff"}, Version: 2}}
+	past := fenceVersion{ID: "1", Data: FenceData{H3Indexes: []string{"872ab259effffff"}, Version: 1}}
+
+	f1, _ := c.pastFence(0, "table", "3333", past)
+	cf, _, _ := c.fence(0, "table", "3333", current)
+	f2, _ := c.pastFence(0, "table", "3333", past)
+	if f1 != f2 {
+		t.Error("Expected the past version to be reused alongside the current one")
+	}
+	if cf == f1 || cf.zones[0].cells[0].String() != "872ab259affffff" {
+		t.Errorf("Expected the current version to be cached apart but got %+v", cf.zones[0])
+	}
+}
+
+func BenchmarkFenceCache(b *testing.B) {
+	for _, n := range []int{1, 100, 10000} {
+		event := &shared.CloudEvent[FenceData]{ID: "1", Data: FenceData{H3Indexes: benchmarkCells(n)}}
+
+		b.Run(strconv.Itoa(n)+"/Parse", func(b *testing.B) {
+			for range b.N {
+				NewFence(event.Data) //nolint
+			}
+		})
+
+		b.Run(strconv.Itoa(n)+"/Cached", func(b *testing.B) {
+			c := newFenceCache(PipelineV1, FenceLimits{}, "")
+			for range b.N {
+				c.fence(0, "table", "3333", event) //nolint
+			}
+		})
+	}
+}
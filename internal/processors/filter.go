@@ -0,0 +1,148 @@
+package processors
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter rule prefixes. A rule without one matches a name exactly.
+const (
+	prefixRule = "prefix:"
+	regexRule  = "regex:"
+)
+
+// allowlistRule labels names removed for not being on the allowlist.
+const allowlistRule = "allowlist"
+
+type filterRule struct {
+	// text is the rule as configured. It is used as a metric label.
+	text   string
+	exact  string
+	prefix string
+	re     *regexp.Regexp
+}
+
+func (r filterRule) matches(name string) bool {
+	switch {
+	case r.re != nil:
+		return r.re.MatchString(name)
+	case r.prefix != "":
+		return strings.HasPrefix(name, r.prefix)
+	default:
+		return name == r.exact
+	}
+}
+
+// Filter removes signals, or V1 overflow keys, by name.
+type Filter struct {
+	deny  []filterRule
+	allow []filterRule
+}
+
+// ParseFilter parses comma-separated deny and allow lists. Each entry is a
+// name, "prefix:" followed by a prefix, or "regex:" followed by a regular
+// expression, which may not contain commas. A name is removed if it matches
+// the denylist or, when there is an allowlist, if it doesn't match that. If
+// both are empty the filter is nil and removes nothing.
+func ParseFilter(deny, allow string) (*Filter, error) {
+	if deny == "" && allow == "" {
+		return nil, nil
+	}
+
+	var f Filter
+	var err error
+	if f.deny, err = parseFilterRules(deny); err != nil {
+		return nil, fmt.Errorf("denylist: %w", err)
+	}
+	if f.allow, err = parseFilterRules(allow); err != nil {
+		return nil, fmt.Errorf("allowlist: %w", err)
+	}
+	return &f, nil
+}
+
+func parseFilterRules(s string) ([]filterRule, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var rules []filterRule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		r := filterRule{text: item}
+
+		switch {
+		case strings.HasPrefix(item, regexRule):
+			re, err := regexp.Compile(strings.TrimPrefix(item, regexRule))
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", item, err)
+			}
+			r.re = re
+		case strings.HasPrefix(item, prefixRule):
+			r.prefix = strings.TrimPrefix(item, prefixRule)
+			if r.prefix == "" {
+				return nil, fmt.Errorf("rule %q: empty prefix", item)
+			}
+		default:
+			if item == "" {
+				return nil, fmt.Errorf("empty rule in %q", s)
+			}
+			r.exact = item
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// removes reports whether name should be removed, and the rule responsible.
+func (f *Filter) removes(name string) (string, bool) {
+	for _, r := range f.deny {
+		if r.matches(name) {
+			return r.text, true
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return "", false
+	}
+	for _, r := range f.allow {
+		if r.matches(name) {
+			return "", false
+		}
+	}
+	return allowlistRule, true
+}
+
+// filterSignals removes the signals of a V2 event that the filter rejects.
+func (f *Filter) filterSignals(pipeline string, event *StatusEventV2[StatusV2Data]) {
+	if f == nil {
+		return
+	}
+
+	kept := event.Data.Vehicle.Signals[:0]
+	for _, signal := range event.Data.Vehicle.Signals {
+		if rule, ok := f.removes(signal.Name); ok {
+			signalsFiltered.WithLabelValues(pipeline, rule).Inc()
+			continue
+		}
+		kept = append(kept, signal)
+	}
+	event.Data.Vehicle.Signals = kept
+}
+
+// filterOverflow removes the keys of a V1 event's overflow that the filter
+// rejects.
+func (f *Filter) filterOverflow(pipeline string, data *StatusData) {
+	if f == nil {
+		return
+	}
+
+	for key := range data.Overflow {
+		if rule, ok := f.removes(key); ok {
+			signalsFiltered.WithLabelValues(pipeline, rule).Inc()
+			delete(data.Overflow, key)
+		}
+	}
+}
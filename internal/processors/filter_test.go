@@ -0,0 +1,165 @@
+package processors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+)
+
+func TestParseFilter(t *testing.T) {
+	if f, err := ParseFilter("", ""); f != nil || err != nil {
+		t.Errorf("Expected no filter but got %v, %v", f, err)
+	}
+
+	for _, tt := range []struct{ deny, allow string }{
+		{"vin,", ""},
+		{"prefix:", ""},
+		{"regex:[", ""},
+		{"", "regex:(a"},
+	} {
+		if _, err := ParseFilter(tt.deny, tt.allow); err == nil {
+			t.Errorf("ParseFilter(%q, %q): expected an error", tt.deny, tt.allow)
+		}
+	}
+}
+
+func TestFilterRemoves(t *testing.T) {
+	tests := []struct {
+		deny, allow string
+		name        string
+		rule        string
+		removed     bool
+	}{
+		{"vin", "", "vin", "vin", true},
+		{"vin", "", "vinNumber", "", false},
+		{"prefix:wifi", "", "wifiBssid", "prefix:wifi", true},
+		{"prefix:wifi", "", "isWifiOn", "", false},
+		{"regex:^(nsat|hdop)$", "", "hdop", "regex:^(nsat|hdop)$", true},
+		{"regex:^(nsat|hdop)$", "", "hdopMax", "", false},
+		{"", "speed,prefix:fuel", "speed", "", false},
+		{"", "speed,prefix:fuel", "fuelPercentRemaining", "", false},
+		{"", "speed,prefix:fuel", "cellId", allowlistRule, true},
+		{"prefix:fuel", "prefix:fuel", "fuelPercentRemaining", "prefix:fuel", true},
+	}
+
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.deny, tt.allow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rule, removed := f.removes(tt.name)
+		if removed != tt.removed || rule != tt.rule {
+			t.Errorf("deny %q, allow %q: removes(%q) = %q, %t, want %q, %t", tt.deny, tt.allow, tt.name, rule, removed, tt.rule, tt.removed)
+		}
+	}
+}
+
+func TestPrivacyV2Filter(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	filter, _ := ParseFilter("vin,prefix:wifi,regex:^cell", "")
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Outputs: []Output{
+			{Stream: "topic.device.status.exact.v2", Policy: Policy{Level: PolicyExact}},
+		},
+		Filter: filter,
+		Logger: &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	exact := gt.NewQueueTracker(string(fg.Outputs[0].Stream))
+
+	before := testutil.ToFloat64(signalsFiltered.WithLabelValues(PipelineV2, "prefix:wifi"))
+
+	gt.Consume(string(fg.StatusInput), "3333", &StatusEventV2[StatusV2Data]{
+		CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+			Vehicle: Vehicle{Signals: []SignalData{
+				{Timestamp: 1, Name: "vin", Value: "WVWZZZ3CZGE000000"},
+				{Timestamp: 1, Name: "speed", Value: 20.0},
+				{Timestamp: 1, Name: "wifiBssid", Value: "00:11:22:33:44:55"},
+				{Timestamp: 1, Name: "wifiSsid", Value: "home"},
+				{Timestamp: 1, Name: "cellId", Value: 1234.0},
+			}},
+		}},
+	})
+
+	for _, tracker := range []*tester.QueueTracker{out, exact} {
+		_, value, ok := tracker.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+
+		signals := value.(*StatusEventV2[StatusV2Data]).Data.Vehicle.Signals
+		if len(signals) != 1 || signals[0].Name != "speed" {
+			t.Errorf("Expected only speed to remain but got %+v", signals)
+		}
+	}
+
+	if d := testutil.ToFloat64(signalsFiltered.WithLabelValues(PipelineV2, "prefix:wifi")) - before; d != 2 {
+		t.Errorf("Expected 2 signals removed by prefix:wifi but got %f", d)
+	}
+}
+
+func TestPrivacyFilter(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	filter, _ := ParseFilter("", "odometer,prefix:fuel")
+
+	fg := Privacy{
+		Group:        "privacy-processor",
+		StatusInput:  "topic.device.status",
+		FenceTable:   "table.device.privacyfence",
+		StatusOutput: "topic.device.status.private",
+		Filter:       filter,
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+
+	gt.Consume(string(fg.StatusInput), "2fbaXmHpdQiKyAH6o5hHTCYwU0U", &shared.CloudEvent[StatusData]{Data: StatusData{
+		Latitude:  ref(42.261123478313145),
+		Longitude: ref(-83.68613574673722),
+		Overflow: map[string]any{
+			"odometer":             1000.0,
+			"fuelPercentRemaining": 0.5,
+			"vin":                  "WVWZZZ3CZGE000000",
+			"wifi":                 map[string]any{"ssid": "home"},
+		},
+	}})
+
+	_, value, ok := out.Next()
+	if !ok {
+		t.Fatal("No output")
+	}
+
+	data := value.(*shared.CloudEvent[StatusData]).Data
+	if len(data.Overflow) != 2 {
+		t.Errorf("Expected odometer and fuelPercentRemaining to remain but got %v", data.Overflow)
+	}
+	if _, ok := data.Overflow["vin"]; ok {
+		t.Error("Expected vin to be removed")
+	}
+	if data.Latitude == nil || *data.Latitude != 42.261123478313145 {
+		t.Error("Expected the location to be kept")
+	}
+}
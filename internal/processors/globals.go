@@ -0,0 +1,241 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+// GlobalFences are fences that apply to every vehicle in addition to its own,
+// such as those around shelters, clinics and places of worship. Outputs with
+// PolicyExact are exempt from them, as from every fence. They are indexed in
+// memory and can be replaced while the processors run. A nil *GlobalFences has
+// no fences.
+type GlobalFences struct {
+	fence atomic.Pointer[Fence]
+}
+
+// LoadGlobalFences reads global fences from a JSON file that holds an object
+// of fence IDs to FenceData.
+func LoadGlobalFences(path string) (*GlobalFences, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fences map[string]FenceData
+	if err := json.Unmarshal(b, &fences); err != nil {
+		return nil, fmt.Errorf("global fences %s: %w", path, err)
+	}
+
+	g := new(GlobalFences)
+	return g, g.Set(fences)
+}
+
+// Set replaces the fences. As with NewFence, malformed shapes are reported in
+// the returned error but the rest is still used.
+func (g *GlobalFences) Set(fences map[string]FenceData) error {
+	ids := make([]string, 0, len(fences))
+	for id := range fences {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	f := &Fence{}
+	var errs []error
+	for _, id := range ids {
+		fence, err := NewFence(fences[id])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("global fence %s: %w", id, err))
+		}
+		for _, z := range fence.zones {
+			z.source = FenceSourceGlobal
+		}
+		f.zones = append(f.zones, fence.zones...)
+	}
+	f.index = newZoneIndex(f.zones)
+
+	g.fence.Store(f)
+	return errors.Join(errs...)
+}
+
+// Fence returns the current fences, or nil if there are none.
+func (g *GlobalFences) Fence() *Fence {
+	if g == nil {
+		return nil
+	}
+	return g.fence.Load()
+}
+
+// SetFromView replaces the fences with those in view, which holds
+// CloudEvent[FenceData] values keyed by fence ID.
+func (g *GlobalFences) SetFromView(view *goka.View) error {
+	it, err := view.Iterator()
+	if err != nil {
+		return err
+	}
+	defer it.Release()
+
+	fences := make(map[string]FenceData)
+	for it.Next() {
+		v, err := it.Value()
+		if err != nil {
+			return fmt.Errorf("global fence %s: %w", it.Key(), err)
+		}
+		if event, ok := v.(*shared.CloudEvent[FenceData]); ok && event != nil {
+			fences[it.Key()] = event.Data
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	return g.Set(fences)
+}
+
+// LoadFromView waits for view to recover and then calls SetFromView, so that
+// the fences are in place before any status is processed. It gives up if ctx
+// is cancelled first.
+func (g *GlobalFences) LoadFromView(ctx context.Context, view *goka.View) error {
+	select {
+	case <-view.WaitRunning():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return g.SetFromView(view)
+}
+
+// Sync calls SetFromView every interval once the view has recovered, until
+// ctx is cancelled. Errors are logged, and the fences that could be read are
+// still used.
+func (g *GlobalFences) Sync(ctx context.Context, view *goka.View, interval time.Duration, logger *zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if view.Recovered() {
+			if err := g.SetFromView(view); err != nil {
+				logger.Err(err).Msg("Problem loading global fences.")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// maxIndexBuckets is the most grid squares a zone may be indexed under.
+// Larger zones are checked for every point.
+const maxIndexBuckets = 64
+
+// zoneIndex buckets zones by the one-degree grid squares that their bounding
+// boxes overlap.
+type zoneIndex struct {
+	buckets map[[2]int][]int
+	// rest are the zones that are checked for every point.
+	rest []int
+}
+
+func newZoneIndex(zones []*zone) *zoneIndex {
+	idx := &zoneIndex{buckets: make(map[[2]int][]int)}
+
+	for i, z := range zones {
+		box, ok := z.bounds()
+		if !ok {
+			idx.rest = append(idx.rest, i)
+			continue
+		}
+
+		minLat, maxLat := int(math.Floor(box.minLat)), int(math.Floor(box.maxLat))
+		minLng, maxLng := int(math.Floor(box.minLng)), int(math.Floor(box.maxLng))
+		if (maxLat-minLat+1)*(maxLng-minLng+1) > maxIndexBuckets {
+			idx.rest = append(idx.rest, i)
+			continue
+		}
+
+		for lat := minLat; lat <= maxLat; lat++ {
+			for lng := minLng; lng <= maxLng; lng++ {
+				key := [2]int{lat, lng}
+				idx.buckets[key] = append(idx.buckets[key], i)
+			}
+		}
+	}
+
+	return idx
+}
+
+// candidates returns the zones that may contain geo, in their original order.
+func (idx *zoneIndex) candidates(zones []*zone, geo h3.LatLng) []*zone {
+	bucket := idx.buckets[[2]int{int(math.Floor(geo.Lat)), int(math.Floor(geo.Lng))}]
+	if len(bucket) == 0 && len(idx.rest) == 0 {
+		return nil
+	}
+
+	indexes := append(slices.Clone(bucket), idx.rest...)
+	slices.Sort(indexes)
+
+	out := make([]*zone, len(indexes))
+	for i, j := range indexes {
+		out[i] = zones[j]
+	}
+	return out
+}
+
+// boundingBox is in degrees.
+type boundingBox struct {
+	minLat, maxLat, minLng, maxLng float64
+}
+
+func (b *boundingBox) add(lat, lng float64) {
+	b.minLat, b.maxLat = min(b.minLat, lat), max(b.maxLat, lat)
+	b.minLng, b.maxLng = min(b.minLng, lng), max(b.maxLng, lng)
+}
+
+// bounds returns a box around every shape of the zone, or false if the zone
+// has no shapes, matches everywhere, or crosses the antimeridian.
+func (z *zone) bounds() (boundingBox, bool) {
+	box := boundingBox{minLat: math.Inf(1), maxLat: math.Inf(-1), minLng: math.Inf(1), maxLng: math.Inf(-1)}
+	if z.everywhere {
+		return box, false
+	}
+
+	for _, c := range z.cells {
+		for _, geo := range c.Boundary() {
+			box.add(geo.Lat, geo.Lng)
+		}
+	}
+	for _, p := range z.polygons {
+		for _, geo := range p[0] {
+			box.add(geo.Lat, geo.Lng)
+		}
+	}
+	for _, c := range z.circles {
+		dLat := c.RadiusMeters / earthRadiusM * 180 / math.Pi
+		cos := math.Cos(c.Latitude * math.Pi / 180)
+		if c.Latitude+dLat >= 90 || c.Latitude-dLat <= -90 || cos <= 0 {
+			return box, false
+		}
+		dLng := dLat / cos
+		box.add(c.Latitude-dLat, c.Longitude-dLng)
+		box.add(c.Latitude+dLat, c.Longitude+dLng)
+	}
+
+	if box.minLat > box.maxLat || box.minLng < -180 || box.maxLng > 180 || box.maxLng-box.minLng > 180 {
+		return box, false
+	}
+	return box, true
+}
@@ -0,0 +1,188 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+func TestGlobalFences(t *testing.T) {
+	inside := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+	outside := h3.NewLatLng(42.261123478313145, -83.68613574673722)
+
+	var g GlobalFences
+	err := g.Set(map[string]FenceData{
+		"clinic": {Circles: []Circle{{Latitude: 42.2617, Longitude: -83.7103, RadiusMeters: 100}}},
+		"shelter": {Geometries: []Geometry{{
+			Type:        "Polygon",
+			Coordinates: json.RawMessage(`[[[2.0, 48.0], [2.1, 48.0], [2.1, 48.1], [2.0, 48.1], [2.0, 48.0]]]`),
+		}}},
+		"world": {Geometries: []Geometry{{
+			Type:        "Polygon",
+			Coordinates: json.RawMessage(`[[[-170, -80], [170, -80], [170, 80], [-170, 80], [-170, -80]]]`),
+		}}, Schedules: []Schedule{{Days: []time.Weekday{time.Sunday}}}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	f := g.Fence()
+	if len(f.index.rest) != 1 {
+		t.Errorf("Expected the large zone to be checked everywhere but got %+v", f.index)
+	}
+
+	// A Monday, when the large zone is inactive.
+	monday := time.Date(2024, 4, 22, 12, 0, 0, 0, time.UTC)
+
+	if m, ok := f.Match(inside, monday); !ok || m.Source != FenceSourceGlobal {
+		t.Errorf("Expected a global match but got %+v, %t", m, ok)
+	}
+	if _, ok := f.Match(outside, monday); ok {
+		t.Error("Expected no match outside the global fences")
+	}
+	if _, ok := f.Match(h3.NewLatLng(48.05, 2.05), monday); !ok {
+		t.Error("Expected a match in the indexed polygon")
+	}
+	if _, ok := f.Match(outside, monday.AddDate(0, 0, 6)); !ok {
+		t.Error("Expected the unindexed zone to match on a Sunday")
+	}
+
+	own, _ := NewFence(FenceData{H3Indexes: []string{"872ab259effffff"}})
+	if m, ok := own.withGlobal(f).Match(inside, monday); !ok || m.Source != FenceSourceVehicle {
+		t.Errorf("Expected the vehicle's fence to take precedence but got %+v, %t", m, ok)
+	}
+	if m, ok := (*Fence)(nil).withGlobal(f).orElse(RemoveRedactor{}).Match(inside, monday); !ok || m.Source != FenceSourceGlobal {
+		t.Errorf("Expected global fences to take precedence over an output policy but got %+v, %t", m, ok)
+	}
+
+	if (*GlobalFences)(nil).Fence() != nil {
+		t.Error("Expected no fence from nil global fences")
+	}
+}
+
+func TestLoadGlobalFences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "global.json")
+	if err := os.WriteFile(path, []byte(`{"clinic": {"h3Indexes": ["872ab259effffff"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := LoadGlobalFences(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := g.Fence().Match(h3.NewLatLng(42.26172693660968, -83.71029708818693), time.Time{}); !ok {
+		t.Error("Expected the loaded fence to match")
+	}
+
+	if err := os.WriteFile(path, []byte(`[]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadGlobalFences(path); err == nil {
+		t.Error("Expected an error for a malformed file")
+	}
+}
+
+func TestGlobalFencesFromView(t *testing.T) {
+	gt := tester.New(t)
+
+	table := goka.Table("table.privacy.globalfence")
+	view, err := goka.NewView([]string{}, table, new(shared.JSONCodec[shared.CloudEvent[FenceData]]), goka.WithViewTester(gt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go view.Run(context.TODO()) //nolint
+
+	gt.SetTableValue(table, "clinic", &shared.CloudEvent[FenceData]{Data: FenceData{H3Indexes: []string{"872ab259effffff"}}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var g GlobalFences
+	if err := g.LoadFromView(ctx, view); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := g.Fence().Match(h3.NewLatLng(42.26172693660968, -83.71029708818693), time.Time{}); !ok {
+		t.Error("Expected the fence from the view to match")
+	}
+}
+
+func TestPrivacyGlobalFences(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	var global GlobalFences
+	if err := global.Set(map[string]FenceData{"clinic": {H3Indexes: []string{"872ab259effffff"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fg := Privacy{
+		Group:        "privacy-processor",
+		StatusInput:  "topic.device.status",
+		FenceTable:   "table.device.privacyfence",
+		StatusOutput: "topic.device.status.private",
+		Outputs:      []Output{{Stream: "topic.device.status.exact", Policy: Policy{Level: PolicyExact}}},
+		GlobalFences: &global,
+		Logger:       &log,
+	}
+	fgV2 := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		GlobalFences: &global,
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+	pV2, _ := goka.NewProcessor([]string{}, fgV2.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO())   //nolint
+	go pV2.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	exact := gt.NewQueueTracker(string(fg.Outputs[0].Stream))
+	outV2 := gt.NewQueueTracker(string(fgV2.StatusOutput))
+
+	// Neither vehicle has a fence of its own.
+	gt.Consume(string(fg.StatusInput), "24c14Q2GGmXRT4JL0Gazu0MJ9XI", &shared.CloudEvent[StatusData]{Data: StatusData{
+		Latitude:  ref(42.26172693660968),
+		Longitude: ref(-83.71029708818693),
+		Overflow:  map[string]any{},
+	}})
+	gt.Consume(string(fgV2.StatusInput), "3333", trajectoryEvent(1713818400000, 42.26172693660968, -83.71029708818693))
+
+	_, value, ok := out.Next()
+	if !ok {
+		t.Fatal("No V1 output")
+	}
+	if data := value.(*shared.CloudEvent[StatusData]).Data; *data.Latitude != 42.25362819577089 || data.RedactionSource != FenceSourceGlobal {
+		t.Errorf("Expected the V1 location to be redacted by a global fence but got %+v", data)
+	}
+
+	_, value, ok = exact.Next()
+	if !ok {
+		t.Fatal("No exact output")
+	}
+	if data := value.(*shared.CloudEvent[StatusData]).Data; *data.Latitude != 42.26172693660968 || data.IsRedacted != nil {
+		t.Errorf("Expected the exact tier to be exempt from global fences but got %+v", data)
+	}
+
+	_, value, ok = outV2.Next()
+	if !ok {
+		t.Fatal("No V2 output")
+	}
+	signals := value.(*StatusEventV2[StatusV2Data]).Data.Vehicle.Signals
+	if signals[0].Value != 42.25362819577089 || signals[3].Name != redactionSourceSignal || signals[3].Value != FenceSourceGlobal {
+		t.Errorf("Expected the V2 location to be redacted by a global fence but got %+v", signals)
+	}
+}
@@ -0,0 +1,141 @@
+package processors
+
+import (
+	"maps"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+)
+
+// fenceVersionExtension is the V2 CloudEvent extension that carries the
+// FenceData.Version used to redact the event.
+const fenceVersionExtension = "fenceversion"
+
+// vehicleState is the group table value of both pipelines.
+type vehicleState struct {
+	// Fences are the most recent versions of the vehicle's fence, oldest
+	// first. They are only kept if FenceHistory is set.
+	Fences []fenceVersion `json:"fences,omitempty"`
+	// trajectoryState is only used by the V2 pipeline with Trajectory set.
+	trajectoryState
+}
+
+// fenceVersion is a version of a vehicle's fence.
+type fenceVersion struct {
+	// ID is the ID of the event that carried the fence.
+	ID   string    `json:"id,omitempty"`
+	Data FenceData `json:"data"`
+}
+
+func stateValue(ctx goka.Context) *vehicleState {
+	if v, ok := ctx.Value().(*vehicleState); ok && v != nil {
+		return v
+	}
+	return new(vehicleState)
+}
+
+// saveState stores state in the group table, or deletes it if it is empty.
+func saveState(ctx goka.Context, state *vehicleState) {
+	if state.empty() {
+		ctx.Delete()
+	} else {
+		ctx.SetValue(state)
+	}
+}
+
+func (s *vehicleState) empty() bool {
+	return len(s.Fences) == 0 && s.trajectoryState.empty()
+}
+
+func (v fenceVersion) effectiveFrom() time.Time {
+	if v.Data.EffectiveFrom == nil {
+		return time.Time{}
+	}
+	return *v.Data.EffectiveFrom
+}
+
+// is reports whether event carries this version of the fence.
+func (v fenceVersion) is(event *shared.CloudEvent[FenceData]) bool {
+	return v.ID == event.ID && v.Data.Version == event.Data.Version
+}
+
+// recordFence adds current, the vehicle's fence as it is in the table now, to
+// the history if it is a new version, keeping at most n versions. It reports
+// whether the history changed.
+func (s *vehicleState) recordFence(current *shared.CloudEvent[FenceData], n int) bool {
+	// Without an ID or a version, a changed fence can't be told apart from
+	// the one before it. A removed fence takes effect at once.
+	if current == nil || current.ID == "" && current.Data.Version == 0 {
+		changed := len(s.Fences) != 0
+		s.Fences = nil
+		return changed
+	}
+
+	if len(s.Fences) != 0 && s.Fences[len(s.Fences)-1].is(current) {
+		return false
+	}
+
+	s.Fences = append(s.Fences, fenceVersion{ID: current.ID, Data: current.Data})
+	if len(s.Fences) > n {
+		s.Fences = s.Fences[len(s.Fences)-n:]
+	}
+	return true
+}
+
+// fenceAt returns the version of the fence that was effective at t: the
+// newest one to have taken effect by then or, if none had, the oldest one
+// known. A zero t means the time is unknown, and the newest version is used.
+func (s *vehicleState) fenceAt(t time.Time) (fenceVersion, bool) {
+	if s == nil || len(s.Fences) == 0 {
+		return fenceVersion{}, false
+	}
+	if t.IsZero() {
+		return s.Fences[len(s.Fences)-1], true
+	}
+
+	best := 0
+	for i, v := range s.Fences {
+		if !v.effectiveFrom().After(t) {
+			best = i
+		}
+	}
+	return s.Fences[best], true
+}
+
+// vehicleFence returns the fence of the vehicle of the current message that
+// was effective at t, if state has kept it, and otherwise the fence in table.
+// It also returns the version of the fence. See NewFence for the error
+// semantics.
+func vehicleFence(ctx goka.Context, table goka.Table, cache *fenceCache, state *vehicleState, t time.Time) (*Fence, int64, error) {
+	current, _ := ctx.Join(table).(*shared.CloudEvent[FenceData])
+
+	if v, ok := state.fenceAt(t); ok && (current == nil || !v.is(current)) {
+		f, err := cache.pastFence(ctx.Partition(), table, ctx.Key(), v)
+		return f, v.Data.Version, err
+	}
+
+	if current == nil {
+		return nil, 0, nil
+	}
+	f, err := readFence(ctx, cache, table, ctx.Key(), current)
+	return f, current.Data.Version, err
+}
+
+// stampFenceVersion records the version of the fence used to redact event in
+// its extensions, replacing any that came with the event.
+func stampFenceVersion(event *StatusEventV2[StatusV2Data], version int64) {
+	if _, ok := event.Extensions[fenceVersionExtension]; !ok && version == 0 {
+		return
+	}
+
+	// Copies of an event share their extensions.
+	ext := make(map[string]any, len(event.Extensions)+1)
+	maps.Copy(ext, event.Extensions)
+	if version == 0 {
+		delete(ext, fenceVersionExtension)
+	} else {
+		ext[fenceVersionExtension] = version
+	}
+	event.Extensions = ext
+}
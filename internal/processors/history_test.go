@@ -0,0 +1,229 @@
+package processors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+)
+
+func TestRecordFence(t *testing.T) {
+	version := func(id string, v int64) *shared.CloudEvent[FenceData] {
+		return &shared.CloudEvent[FenceData]{ID: id, Data: FenceData{Version: v}}
+	}
+
+	var s vehicleState
+	if !s.recordFence(version("a", 1), 2) {
+		t.Error("First version not recorded")
+	}
+	if s.recordFence(version("a", 1), 2) {
+		t.Error("Same version recorded twice")
+	}
+	if !s.recordFence(version("b", 2), 2) || !s.recordFence(version("c", 3), 2) {
+		t.Error("New versions not recorded")
+	}
+	if len(s.Fences) != 2 || s.Fences[0].Data.Version != 2 || s.Fences[1].Data.Version != 3 {
+		t.Errorf("Expected versions 2 and 3 but got %+v", s.Fences)
+	}
+
+	if !s.recordFence(nil, 2) || len(s.Fences) != 0 {
+		t.Errorf("Expected a removed fence to clear the history but got %+v", s.Fences)
+	}
+	if s.recordFence(version("", 0), 2) {
+		t.Error("Unversioned fence recorded")
+	}
+}
+
+func TestFenceAt(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := &vehicleState{Fences: []fenceVersion{
+		{ID: "a", Data: FenceData{Version: 1}},
+		{ID: "b", Data: FenceData{Version: 2, EffectiveFrom: ref(t0)}},
+		{ID: "c", Data: FenceData{Version: 3, EffectiveFrom: ref(t0.Add(time.Hour))}},
+	}}
+
+	tests := []struct {
+		name    string
+		time    time.Time
+		version int64
+	}{
+		{"Before", t0.Add(-time.Minute), 1},
+		{"At", t0, 2},
+		{"Between", t0.Add(time.Minute), 2},
+		{"After", t0.Add(2 * time.Hour), 3},
+		{"Unknown", time.Time{}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := s.fenceAt(tt.time)
+			if !ok || v.Data.Version != tt.version {
+				t.Errorf("Expected version %d but got %+v", tt.version, v)
+			}
+		})
+	}
+
+	// Before any version took effect, the oldest one known is used.
+	late := &vehicleState{Fences: s.Fences[1:]}
+	if v, _ := late.fenceAt(t0.Add(-time.Minute)); v.Data.Version != 2 {
+		t.Errorf("Expected version 2 but got %+v", v)
+	}
+}
+
+func TestPrivacyFenceHistory(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := Privacy{
+		Group:        "privacy-processor",
+		StatusInput:  "topic.device.status",
+		FenceTable:   "table.device.privacyfence",
+		StatusOutput: "topic.device.status.private",
+		FenceHistory: 3,
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+
+	deviceID := "24c14Q2GGmXRT4JL0Gazu0MJ9XI"
+	edited := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	consume := func(at time.Time) StatusData {
+		t.Helper()
+		gt.Consume(string(fg.StatusInput), deviceID, &shared.CloudEvent[StatusData]{
+			Time: at,
+			Data: StatusData{
+				Latitude:  ref(42.26172693660968),
+				Longitude: ref(-83.71029708818693),
+				Overflow:  map[string]any{},
+			},
+		})
+
+		_, value, ok := out.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+		return value.(*shared.CloudEvent[StatusData]).Data
+	}
+
+	gt.SetTableValue(fg.FenceTable, deviceID, &shared.CloudEvent[FenceData]{ID: "1", Data: FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+		Version:   1,
+	}})
+	if data := consume(edited.Add(-time.Hour)); !*data.IsRedacted || data.FenceVersion != 1 {
+		t.Errorf("Expected redaction with version 1 but got %+v", data)
+	}
+
+	// The zone is removed, but only from noon.
+	gt.SetTableValue(fg.FenceTable, deviceID, &shared.CloudEvent[FenceData]{ID: "2", Data: FenceData{
+		Version:       2,
+		EffectiveFrom: ref(edited),
+	}})
+
+	tests := []struct {
+		name     string
+		time     time.Time
+		redacted bool
+		version  int64
+	}{
+		{"Late", edited.Add(-time.Minute), true, 1},
+		{"Current", edited.Add(time.Minute), false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := consume(tt.time)
+			if *data.IsRedacted != tt.redacted || data.FenceVersion != tt.version {
+				t.Errorf("Expected redaction %t with version %d but got %+v", tt.redacted, tt.version, data)
+			}
+		})
+	}
+}
+
+func TestPrivacyV2FenceHistory(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		FenceHistory: 3,
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+
+	tokenID := "123"
+	edited := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	gt.SetTableValue(fg.FenceTable, tokenID, &shared.CloudEvent[FenceData]{ID: "1", Data: FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+		Version:   1,
+	}})
+
+	consume := func(at time.Time) *StatusEventV2[StatusV2Data] {
+		t.Helper()
+		gt.Consume(string(fg.StatusInput), tokenID, &StatusEventV2[StatusV2Data]{
+			CloudEvent: shared.CloudEvent[StatusV2Data]{
+				Time: at,
+				Data: StatusV2Data{Vehicle: Vehicle{Signals: []SignalData{
+					{Timestamp: at.UnixMilli(), Name: "latitude", Value: 42.26172693660968},
+					{Timestamp: at.UnixMilli(), Name: "longitude", Value: -83.71029708818693},
+				}}},
+			},
+			// Upstream values are replaced.
+			Extensions: map[string]any{fenceVersionExtension: 7},
+		})
+
+		_, value, ok := out.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+		return value.(*StatusEventV2[StatusV2Data])
+	}
+
+	consume(edited.Add(-time.Hour))
+
+	gt.SetTableValue(fg.FenceTable, tokenID, &shared.CloudEvent[FenceData]{ID: "2", Data: FenceData{
+		Version:       2,
+		EffectiveFrom: ref(edited),
+	}})
+
+	tests := []struct {
+		name     string
+		time     time.Time
+		redacted bool
+		version  float64
+	}{
+		{"Late", edited.Add(-time.Minute), true, 1},
+		{"Current", edited.Add(time.Minute), false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := consume(tt.time)
+			if v := event.Extensions[fenceVersionExtension]; v != tt.version {
+				t.Errorf("Expected fence version %v but got %v", tt.version, v)
+			}
+			for _, s := range event.Data.Vehicle.Signals {
+				if s.Name == "IsRedacted" && s.Value != tt.redacted {
+					t.Errorf("Expected IsRedacted %t but got %v", tt.redacted, s.Value)
+				}
+			}
+		})
+	}
+}
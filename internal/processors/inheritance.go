@@ -0,0 +1,206 @@
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+)
+
+// More fence sources, for fences inherited through Inheritance.
+const (
+	// FenceSourceOwner is the fence of the account that owns the vehicle.
+	FenceSourceOwner = "owner"
+	// FenceSourceFleet is the fence of the fleet the vehicle belongs to.
+	FenceSourceFleet = "fleet"
+)
+
+// Merge policies for inherited fences.
+const (
+	// MergeUnion matches the zones of every fence, trying them in order of
+	// precedence. Where zones overlap, the one from the fence that comes
+	// first decides the redaction.
+	MergeUnion = "union"
+	// MergeOverride uses only the first fence in order of precedence that
+	// has any zones.
+	MergeOverride = "override"
+)
+
+// DefaultFencePrecedence puts the vehicle's own fence first, then its owner's
+// and then its fleet's.
+var DefaultFencePrecedence = []string{FenceSourceVehicle, FenceSourceOwner, FenceSourceFleet}
+
+// VehicleMapping is the value of the mapping table. It tells the account and
+// fleet a vehicle belongs to. Either may be empty.
+type VehicleMapping struct {
+	Owner   string `json:"owner,omitempty"`
+	FleetID string `json:"fleetId,omitempty"`
+}
+
+// Inheritance merges the fences of a vehicle's owner and fleet into the
+// vehicle's own fence.
+type Inheritance struct {
+	// MappingTable holds CloudEvent[VehicleMapping] values keyed like the
+	// status input. It is joined.
+	MappingTable goka.Table
+	// OwnerFenceTable and FleetFenceTable hold CloudEvent[FenceData] values
+	// keyed by owner and by fleet ID. They are looked up, and either may be
+	// empty.
+	OwnerFenceTable goka.Table
+	FleetFenceTable goka.Table
+	// Precedence orders the fence sources. Defaults to
+	// DefaultFencePrecedence. See ParseFencePrecedence.
+	Precedence []string
+	// Merge is one of MergeUnion and MergeOverride. Defaults to MergeUnion.
+	Merge string
+}
+
+// ParseFencePrecedence parses a comma-separated order of the vehicle, owner
+// and fleet fence sources, each of which must appear exactly once. An empty
+// string means DefaultFencePrecedence.
+func ParseFencePrecedence(s string) ([]string, error) {
+	if s == "" {
+		return DefaultFencePrecedence, nil
+	}
+
+	var out []string
+	for _, src := range strings.Split(s, ",") {
+		src = strings.TrimSpace(src)
+		switch src {
+		case FenceSourceVehicle, FenceSourceOwner, FenceSourceFleet:
+		default:
+			return nil, fmt.Errorf("unknown fence source %q", src)
+		}
+		for _, o := range out {
+			if o == src {
+				return nil, fmt.Errorf("fence source %q listed twice", src)
+			}
+		}
+		out = append(out, src)
+	}
+
+	if len(out) != len(DefaultFencePrecedence) {
+		return nil, fmt.Errorf("fence precedence %q must list each of %s", s, strings.Join(DefaultFencePrecedence, ", "))
+	}
+	return out, nil
+}
+
+// ParseMergePolicy checks a merge policy. An empty string means MergeUnion.
+func ParseMergePolicy(s string) (string, error) {
+	switch s {
+	case "":
+		return MergeUnion, nil
+	case MergeUnion, MergeOverride:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown merge policy %q", s)
+	}
+}
+
+// edges returns the tables that in needs.
+func (in *Inheritance) edges() []goka.Edge {
+	if in == nil {
+		return nil
+	}
+
+	edges := []goka.Edge{goka.Join(in.MappingTable, new(shared.JSONCodec[shared.CloudEvent[VehicleMapping]]))}
+	if in.OwnerFenceTable != "" {
+		edges = append(edges, goka.Lookup(in.OwnerFenceTable, new(shared.JSONCodec[shared.CloudEvent[FenceData]])))
+	}
+	if in.FleetFenceTable != "" {
+		edges = append(edges, goka.Lookup(in.FleetFenceTable, new(shared.JSONCodec[shared.CloudEvent[FenceData]])))
+	}
+	return edges
+}
+
+// fence merges own, the fence of the vehicle of the current message, with
+// those of its owner and fleet. err is the error from reading own. A nil
+// *Inheritance returns own. See NewFence for the error semantics.
+func (in *Inheritance) fence(ctx goka.Context, own *Fence, err error, cache *fenceCache) (*Fence, error) {
+	if in == nil {
+		return own, err
+	}
+
+	errs := []error{err}
+	fences := map[string]*Fence{FenceSourceVehicle: own}
+
+	if v := ctx.Join(in.MappingTable); v != nil {
+		mapping := v.(*shared.CloudEvent[VehicleMapping]).Data
+		for _, l := range []struct {
+			source string
+			table  goka.Table
+			key    string
+		}{
+			{FenceSourceOwner, in.OwnerFenceTable, mapping.Owner},
+			{FenceSourceFleet, in.FleetFenceTable, mapping.FleetID},
+		} {
+			if l.table == "" || l.key == "" {
+				continue
+			}
+			f, err := lookupFence(ctx, l.table, l.key, cache)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s fence %s: %w", l.source, l.key, err))
+			}
+			fences[l.source] = f
+		}
+	}
+
+	return in.merge(fences), errors.Join(errs...)
+}
+
+func lookupFence(ctx goka.Context, table goka.Table, key string, cache *fenceCache) (*Fence, error) {
+	val := ctx.Lookup(table, key)
+	if val == nil {
+		return nil, nil
+	}
+
+	// An owner or fleet with vehicles on several partitions is cached once
+	// for each.
+	return readFence(ctx, cache, table, key, val.(*shared.CloudEvent[FenceData]))
+}
+
+// merge combines fences, keyed by source, as configured.
+func (in *Inheritance) merge(fences map[string]*Fence) *Fence {
+	precedence := in.Precedence
+	if len(precedence) == 0 {
+		precedence = DefaultFencePrecedence
+	}
+
+	var ordered []string
+	for _, src := range precedence {
+		if f := fences[src]; !f.Empty() {
+			ordered = append(ordered, src)
+			if in.Merge == MergeOverride {
+				break
+			}
+		}
+	}
+
+	// Asking for trip endpoints to be obfuscated only adds privacy, so any
+	// fence can ask for it.
+	tripEndpoints := false
+	for _, f := range fences {
+		tripEndpoints = tripEndpoints || f.obfuscatesTripEndpoints()
+	}
+
+	own := fences[FenceSourceVehicle]
+	if (len(ordered) == 0 || len(ordered) == 1 && ordered[0] == FenceSourceVehicle) && tripEndpoints == own.obfuscatesTripEndpoints() {
+		return own
+	}
+
+	out := &Fence{tripEndpoints: tripEndpoints}
+	// Fence-wide companions of the fences that come first win.
+	for i := len(ordered) - 1; i >= 0; i-- {
+		out.companions = mergeCompanions(out.companions, fences[ordered[i]].companions)
+	}
+	for _, src := range ordered {
+		for _, z := range fences[src].zones {
+			zc := *z
+			zc.source = src
+			out.zones = append(out.zones, &zc)
+		}
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package processors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+func TestPrivacyInheritance(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	inheritance := &Inheritance{
+		MappingTable:    "table.device.mapping",
+		OwnerFenceTable: "table.owner.privacyfence",
+		FleetFenceTable: "table.fleet.privacyfence",
+	}
+	fg := Privacy{
+		Group:        "privacy-processor",
+		StatusInput:  "topic.device.status",
+		FenceTable:   "table.device.privacyfence",
+		StatusOutput: "topic.device.status.private",
+		Inheritance:  inheritance,
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+
+	deviceID := "24c14Q2GGmXRT4JL0Gazu0MJ9XI"
+	owner := "0x71C7656EC7ab88b098defB751B7401B5f6d8976F"
+
+	gt.SetTableValue(inheritance.MappingTable, deviceID, &shared.CloudEvent[VehicleMapping]{Data: VehicleMapping{
+		Owner:   owner,
+		FleetID: "fleet-1",
+	}})
+	gt.SetTableValue(inheritance.OwnerFenceTable, owner, &shared.CloudEvent[FenceData]{Data: FenceData{
+		Circles: []Circle{{Latitude: 42.261123478313145, Longitude: -83.68613574673722, RadiusMeters: 100}},
+	}})
+	gt.SetTableValue(inheritance.FleetFenceTable, "fleet-1", &shared.CloudEvent[FenceData]{Data: FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+	}})
+
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		source    string
+	}{
+		{"Owner", 42.261123478313145, -83.68613574673722, FenceSourceOwner},
+		{"Fleet", 42.26172693660968, -83.71029708818693, FenceSourceFleet},
+		{"Neither", 42.3, -83.8, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gt.Consume(string(fg.StatusInput), deviceID, &shared.CloudEvent[StatusData]{Data: StatusData{
+				Latitude:  ref(tt.latitude),
+				Longitude: ref(tt.longitude),
+				Overflow:  map[string]any{},
+			}})
+
+			_, value, ok := out.Next()
+			if !ok {
+				t.Fatal("No output")
+			}
+			data := value.(*shared.CloudEvent[StatusData]).Data
+			if *data.IsRedacted != (tt.source != "") || data.RedactionSource != tt.source {
+				t.Errorf("Expected redaction source %q but got %+v", tt.source, data)
+			}
+		})
+	}
+}
+
+func TestInheritanceMerge(t *testing.T) {
+	inside := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+
+	own, _ := NewFence(FenceData{
+		Circles:    []Circle{{Latitude: 0, Longitude: 0, RadiusMeters: 100}},
+		Companions: []Companion{{Name: "speed", Action: CompanionDrop}},
+	})
+	fleet, _ := NewFence(FenceData{
+		H3Indexes:     []string{"872ab259effffff"},
+		Companions:    []Companion{{Name: "speed", Action: CompanionCoarsen, Step: 10}},
+		TripEndpoints: true,
+	})
+	fences := map[string]*Fence{FenceSourceVehicle: own, FenceSourceFleet: fleet}
+
+	union := (&Inheritance{}).merge(fences)
+	if m, ok := union.Match(inside, time.Time{}); !ok || m.Source != FenceSourceFleet {
+		t.Errorf("Expected the fleet's zone to match but got %+v, %t", m, ok)
+	}
+	if !union.obfuscatesTripEndpoints() {
+		t.Error("Expected the fleet to turn on trip endpoint obfuscation")
+	}
+	if len(union.companions) != 1 || union.companions[0].Action != CompanionDrop {
+		t.Errorf("Expected the vehicle's companions to win but got %+v", union.companions)
+	}
+
+	override := (&Inheritance{Merge: MergeOverride}).merge(fences)
+	if _, ok := override.Match(inside, time.Time{}); ok {
+		t.Error("Expected the vehicle's fence to override the fleet's")
+	}
+
+	fleetFirst := (&Inheritance{Precedence: []string{FenceSourceFleet, FenceSourceOwner, FenceSourceVehicle}, Merge: MergeOverride}).merge(fences)
+	if m, ok := fleetFirst.Match(inside, time.Time{}); !ok || m.Source != FenceSourceFleet {
+		t.Errorf("Expected the fleet's fence to override the vehicle's but got %+v, %t", m, ok)
+	}
+
+	if f := (&Inheritance{}).merge(map[string]*Fence{FenceSourceVehicle: own}); f != own {
+		t.Error("Expected the vehicle's fence to be used as it is")
+	}
+}
+
+func TestParseFencePrecedence(t *testing.T) {
+	if p, err := ParseFencePrecedence(" fleet, vehicle ,owner"); err != nil || p[0] != FenceSourceFleet || p[1] != FenceSourceVehicle || p[2] != FenceSourceOwner {
+		t.Errorf("Unexpected precedence %v, %v", p, err)
+	}
+
+	for _, s := range []string{"vehicle,owner", "vehicle,owner,fleet,owner", "vehicle,owner,global"} {
+		if _, err := ParseFencePrecedence(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+
+	if m, err := ParseMergePolicy(""); err != nil || m != MergeUnion {
+		t.Errorf("Unexpected merge policy %q, %v", m, err)
+	}
+	if _, err := ParseMergePolicy("intersect"); err == nil {
+		t.Error("Expected an error for an unknown merge policy")
+	}
+}
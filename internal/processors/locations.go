@@ -0,0 +1,347 @@
+package processors
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/h3-go/v4"
+)
+
+// LocationSignals names the V2 signals that make up one representation of a
+// vehicle's location.
+type LocationSignals struct {
+	Latitude  string
+	Longitude string
+	// Companions are signals such as altitude or heading that describe the
+	// same position. They are removed whenever the location is redacted.
+	Companions []string
+}
+
+// DefaultLocationSignals are used when no location signals are configured.
+var DefaultLocationSignals = []LocationSignals{
+	{Latitude: "latitude", Longitude: "longitude"},
+	{
+		Latitude:   "currentLocationLatitude",
+		Longitude:  "currentLocationLongitude",
+		Companions: []string{"currentLocationAltitude", "currentLocationHeading"},
+	},
+}
+
+// ParseLocationSignals parses a comma-separated list of location signals of
+// the form "latitude:longitude[:companion...]". An empty string means
+// DefaultLocationSignals.
+func ParseLocationSignals(s string) ([]LocationSignals, error) {
+	if s == "" {
+		return DefaultLocationSignals, nil
+	}
+
+	seen := make(map[string]bool)
+
+	var out []LocationSignals
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("location signals %q are not of the form latitude:longitude", item)
+		}
+
+		for _, name := range parts {
+			if name == "" {
+				return nil, fmt.Errorf("location signals %q: empty signal name", item)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("location signals %q: signal %s is listed more than once", item, name)
+			}
+			seen[name] = true
+		}
+
+		l := LocationSignals{Latitude: parts[0], Longitude: parts[1]}
+		if len(parts) > 2 {
+			l.Companions = parts[2:]
+		}
+		out = append(out, l)
+	}
+
+	return out, nil
+}
+
+// Orphan policies, for latitude and longitude signals that have no partner
+// within the pairing tolerance.
+const (
+	// OrphanDrop removes orphaned coordinates if the vehicle has a fence.
+	OrphanDrop = "drop"
+	// OrphanNearest redacts orphaned coordinates the same way as the pair
+	// nearest in time. If that pair was left in the clear, the orphan is
+	// matched against the fence along with the pair's other coordinate. If
+	// there is no such pair, they are dropped.
+	OrphanNearest = "nearest"
+	// OrphanCoarsen rounds every orphaned coordinate to a tenth of a degree,
+	// whether or not the vehicle has a fence.
+	OrphanCoarsen = "coarsen"
+)
+
+// ParseOrphanPolicy checks an orphan policy. An empty string means OrphanDrop.
+func ParseOrphanPolicy(s string) (string, error) {
+	switch s {
+	case "":
+		return OrphanDrop, nil
+	case OrphanDrop, OrphanNearest, OrphanCoarsen:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown orphan policy %q", s)
+	}
+}
+
+// locationOptions controls how locations are found in V2 signals.
+type locationOptions struct {
+	signals []LocationSignals
+	// tolerance is the largest difference, in milliseconds, between the
+	// timestamps of a paired latitude and longitude.
+	tolerance int64
+	orphans   string
+	// failClosed removes coordinates that aren't numbers instead of passing
+	// them through.
+	failClosed bool
+	// nearZone, if set, decides whether a location outside every zone is
+	// close enough to one to be redacted along with it.
+	nearZone func(geo h3.LatLng, t time.Time) (trajectoryAnchor, bool)
+}
+
+func (o locationOptions) near(geo h3.LatLng, t time.Time) (trajectoryAnchor, bool) {
+	if o.nearZone == nil {
+		return trajectoryAnchor{}, false
+	}
+	return o.nearZone(geo, t)
+}
+
+// coordinate converts a signal value to a coordinate. Besides JSON numbers it
+// accepts the other Go numeric types and numeric strings. NaN and infinities
+// are rejected.
+func coordinate(v any) (float64, bool) {
+	var f float64
+	switch v := v.(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	case int32:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case uint:
+		f = float64(v)
+	case uint32:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			return 0, false
+		}
+		f = n
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, false
+		}
+		f = n
+	default:
+		return 0, false
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// locationPair is a latitude and a longitude signal that describe the same
+// position.
+type locationPair struct {
+	lat, lng   int
+	companions []int
+	// timestamp is that of the latitude.
+	timestamp int64
+}
+
+// orphan is a latitude or longitude signal without a partner.
+type orphan struct {
+	index    int
+	latitude bool
+}
+
+// pairLocations pairs every latitude with the longitude of the same
+// representation that is nearest in time, within tolerance milliseconds.
+// Pairs are returned in order of first appearance, and the signals left over
+// are returned as orphans.
+func pairLocations(signals []SignalData, locations []LocationSignals, tolerance int64) ([]locationPair, []orphan) {
+	var pairs []locationPair
+	var orphans []orphan
+
+	for _, names := range locations {
+		var lats, lngs, companions []int
+		for i, s := range signals {
+			switch {
+			case s.Name == names.Latitude:
+				lats = append(lats, i)
+			case s.Name == names.Longitude:
+				lngs = append(lngs, i)
+			case slices.Contains(names.Companions, s.Name):
+				companions = append(companions, i)
+			}
+		}
+
+		type candidate struct{ lat, lng int }
+		var candidates []candidate
+		for _, lat := range lats {
+			for _, lng := range lngs {
+				if timeDistance(signals[lat].Timestamp, signals[lng].Timestamp) <= tolerance {
+					candidates = append(candidates, candidate{lat, lng})
+				}
+			}
+		}
+
+		// Closest first. The sort is stable, so ties go to the earliest signals.
+		slices.SortStableFunc(candidates, func(a, b candidate) int {
+			return cmp.Compare(
+				timeDistance(signals[a.lat].Timestamp, signals[a.lng].Timestamp),
+				timeDistance(signals[b.lat].Timestamp, signals[b.lng].Timestamp),
+			)
+		})
+
+		paired := make(map[int]bool)
+		for _, c := range candidates {
+			if paired[c.lat] || paired[c.lng] {
+				continue
+			}
+			paired[c.lat] = true
+			paired[c.lng] = true
+
+			p := locationPair{lat: c.lat, lng: c.lng, timestamp: signals[c.lat].Timestamp}
+			for _, i := range companions {
+				if timeDistance(signals[i].Timestamp, p.timestamp) <= tolerance {
+					p.companions = append(p.companions, i)
+				}
+			}
+			pairs = append(pairs, p)
+		}
+
+		for _, i := range lats {
+			if !paired[i] {
+				orphans = append(orphans, orphan{index: i, latitude: true})
+			}
+		}
+		for _, i := range lngs {
+			if !paired[i] {
+				orphans = append(orphans, orphan{index: i})
+			}
+		}
+	}
+
+	slices.SortFunc(pairs, func(a, b locationPair) int {
+		return cmp.Compare(min(a.lat, a.lng), min(b.lat, b.lng))
+	})
+
+	return pairs, orphans
+}
+
+func timeDistance(a, b int64) int64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// pairDecision records what happened to a pair, for use by OrphanNearest.
+type pairDecision struct {
+	timestamp int64
+	// in is the location as it was received.
+	in       h3.LatLng
+	redacted bool
+	// out is the redacted location, if the pair was redacted but not removed.
+	out     h3.LatLng
+	removed bool
+	// source is the FenceSource that caused the redaction, if any.
+	source string
+}
+
+// sanitizeOrphans applies the orphan policy to orphaned coordinates and returns
+// the indexes of those that should be removed.
+func sanitizeOrphans(event *StatusEventV2[StatusV2Data], orphans []orphan, decisions []pairDecision, fence *Fence, redactor Redactor, opts locationOptions) []int {
+	var drop []int
+
+	for _, o := range orphans {
+		signal := &event.Data.Vehicle.Signals[o.index]
+
+		v, ok := coordinate(signal.Value)
+		if !ok && opts.failClosed {
+			drop = append(drop, o.index)
+			continue
+		}
+
+		switch opts.orphans {
+		case OrphanCoarsen:
+			if ok {
+				signal.Value = math.Round(v*10) / 10
+			}
+		case OrphanNearest:
+			if fence.Empty() {
+				continue
+			}
+			d, found := nearestDecision(decisions, signal.Timestamp)
+			switch {
+			case !found || d.removed:
+				drop = append(drop, o.index)
+			case d.redacted && o.latitude:
+				signal.Value = d.out.Lat
+			case d.redacted:
+				signal.Value = d.out.Lng
+			case ok:
+				// The pair was in the clear, but the orphan may not be.
+				geo := h3.NewLatLng(d.in.Lat, v)
+				if o.latitude {
+					geo = h3.NewLatLng(v, d.in.Lng)
+				}
+				m, matched := fence.Match(geo, signalTime(event, signal.Timestamp))
+				if !matched {
+					continue
+				}
+				out, kept := redact(geo, m, redactor)
+				switch {
+				case !kept:
+					drop = append(drop, o.index)
+				case o.latitude:
+					signal.Value = out.Lat
+				default:
+					signal.Value = out.Lng
+				}
+			}
+		default:
+			if !fence.Empty() {
+				drop = append(drop, o.index)
+			}
+		}
+	}
+
+	return drop
+}
+
+func nearestDecision(decisions []pairDecision, timestamp int64) (pairDecision, bool) {
+	var best pairDecision
+	found := false
+	for _, d := range decisions {
+		if !found || timeDistance(d.timestamp, timestamp) < timeDistance(best.timestamp, timestamp) {
+			best = d
+			found = true
+		}
+	}
+	return best, found
+}
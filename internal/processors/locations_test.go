@@ -0,0 +1,345 @@
+package processors
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/DIMO-Network/shared"
+)
+
+func TestParseLocationSignals(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []LocationSignals
+		err   bool
+	}{
+		{"", DefaultLocationSignals, false},
+		{"lat:lng", []LocationSignals{{Latitude: "lat", Longitude: "lng"}}, false},
+		{
+			"latitude:longitude, gpsLat:gpsLng:gpsAlt:gpsHeading",
+			[]LocationSignals{
+				{Latitude: "latitude", Longitude: "longitude"},
+				{Latitude: "gpsLat", Longitude: "gpsLng", Companions: []string{"gpsAlt", "gpsHeading"}},
+			},
+			false,
+		},
+		{"lat", nil, true},
+		{"lat:", nil, true},
+		{"lat:lng:", nil, true},
+		{"lat:lng,lat:lng2", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLocationSignals(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseLocationSignals(%q): expected error %t but got %v", tt.input, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLocationSignals(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeEventV2LocationSignals(t *testing.T) {
+	fence, _ := NewFence(FenceData{H3Indexes: []string{"872ab259effffff"}})
+
+	ts := int64(1713818407248)
+	event := &StatusEventV2[StatusV2Data]{CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+		Vehicle: Vehicle{Signals: []SignalData{
+			{Timestamp: ts, Name: "speed", Value: 20.0},
+			{Timestamp: ts, Name: "currentLocationLatitude", Value: 42.26172693660968},
+			{Timestamp: ts, Name: "currentLocationLongitude", Value: -83.71029708818693},
+			{Timestamp: ts, Name: "currentLocationAltitude", Value: 270.0},
+			{Timestamp: ts, Name: "currentLocationHeading", Value: 90.0},
+		}},
+	}}}
+
+	redacted, unredacted := sanitizeEventV2(event, fence, ParentRedactor{}, locationOptions{signals: DefaultLocationSignals})
+	if redacted != 1 || unredacted != 0 {
+		t.Errorf("Expected 1 redacted and 0 unredacted locations but got %d and %d", redacted, unredacted)
+	}
+
+	want := []SignalData{
+		{Timestamp: ts, Name: "speed", Value: 20.0},
+		{Timestamp: ts, Name: "currentLocationLatitude", Value: 42.25362819577089},
+		{Timestamp: ts, Name: "currentLocationLongitude", Value: -83.68562802176137},
+		{Timestamp: ts, Name: "IsRedacted", Value: true},
+		{Timestamp: ts, Name: "redactionSource", Value: FenceSourceVehicle},
+		{Timestamp: ts, Name: "redactedLocationCount", Value: 1.0},
+	}
+	if !reflect.DeepEqual(event.Data.Vehicle.Signals, want) {
+		t.Errorf("Expected signals %+v but got %+v", want, event.Data.Vehicle.Signals)
+	}
+
+	// Names outside the configured set are left alone.
+	event.Data.Vehicle.Signals = []SignalData{
+		{Timestamp: ts, Name: "latitude", Value: 42.26172693660968},
+		{Timestamp: ts, Name: "longitude", Value: -83.71029708818693},
+	}
+
+	redacted, unredacted = sanitizeEventV2(event, fence, ParentRedactor{}, locationOptions{signals: []LocationSignals{{Latitude: "gpsLat", Longitude: "gpsLng"}}})
+	if redacted != 0 || unredacted != 0 || len(event.Data.Vehicle.Signals) != 2 {
+		t.Errorf("Expected unconfigured signals to be ignored but got %+v", event.Data.Vehicle.Signals)
+	}
+
+	if err := validateStatusV2(&StatusV2Data{Vehicle: Vehicle{Signals: []SignalData{
+		{Name: "currentLocationLatitude", Value: 91.0},
+	}}}, DefaultLocationSignals); err == nil {
+		t.Error("Expected an out of range currentLocationLatitude to be invalid")
+	}
+
+}
+
+func TestSanitizeEventV2Pairing(t *testing.T) {
+	fence, _ := NewFence(FenceData{H3Indexes: []string{"872ab259effffff"}})
+
+	const (
+		ts = int64(1713818407248)
+
+		fencedLat   = 42.26172693660968
+		fencedLng   = -83.71029708818693
+		redactedLat = 42.25362819577089
+		redactedLng = -83.68562802176137
+		clearLat    = 42.261123478313145
+		clearLng    = -83.68613574673722
+	)
+
+	lat := func(ts int64, v float64) SignalData { return SignalData{Timestamp: ts, Name: "latitude", Value: v} }
+	lng := func(ts int64, v float64) SignalData { return SignalData{Timestamp: ts, Name: "longitude", Value: v} }
+	flag := func(ts int64, v bool) SignalData { return SignalData{Timestamp: ts, Name: "IsRedacted", Value: v} }
+	source := func(ts int64) SignalData {
+		return SignalData{Timestamp: ts, Name: "redactionSource", Value: FenceSourceVehicle}
+	}
+	count := func(n float64) SignalData { return SignalData{Timestamp: ts, Name: "redactedLocationCount", Value: n} }
+
+	tests := []struct {
+		name      string
+		tolerance int64
+		orphans   string
+		noFence   bool
+		// globalOnly uses the fence as a global fence only.
+		globalOnly bool
+		signals    []SignalData
+		want       []SignalData
+	}{
+		{
+			name:    "ExactTimestamps",
+			signals: []SignalData{lat(ts, fencedLat), lng(ts, fencedLng)},
+			want:    []SignalData{lat(ts, redactedLat), lng(ts, redactedLng), flag(ts, true), source(ts), count(1)},
+		},
+		{
+			name:      "WithinTolerance",
+			tolerance: 100,
+			signals:   []SignalData{lat(ts, fencedLat), lng(ts+5, fencedLng)},
+			want:      []SignalData{lat(ts, redactedLat), lng(ts+5, redactedLng), flag(ts, true), source(ts), count(1)},
+		},
+		{
+			name:      "OutsideTolerance",
+			tolerance: 100,
+			signals:   []SignalData{lat(ts, fencedLat), lng(ts+500, fencedLng)},
+			want:      []SignalData{},
+		},
+		{
+			name:      "NearestLongitudeWins",
+			tolerance: 100,
+			signals:   []SignalData{lat(ts, fencedLat), lng(ts+50, clearLng), lng(ts+10, fencedLng)},
+			want:      []SignalData{lat(ts, redactedLat), lng(ts+10, redactedLng), flag(ts, true), source(ts), count(1)},
+		},
+		{
+			name:      "EachLatitudeGetsItsOwnLongitude",
+			tolerance: 100,
+			signals:   []SignalData{lat(ts, clearLat), lat(ts+20, clearLat), lng(ts+15, clearLng), lng(ts+1, clearLng)},
+			want: []SignalData{
+				lat(ts, clearLat), lat(ts+20, clearLat), lng(ts+15, clearLng), lng(ts+1, clearLng),
+				flag(ts, false), flag(ts+20, false), count(0),
+			},
+		},
+		{
+			name:    "OrphanDropped",
+			orphans: OrphanDrop,
+			signals: []SignalData{lat(ts, clearLat), lng(ts, clearLng), lat(ts+1000, fencedLat)},
+			want:    []SignalData{lat(ts, clearLat), lng(ts, clearLng), flag(ts, false), count(0)},
+		},
+		{
+			name:    "OrphanKeptWithoutFence",
+			orphans: OrphanDrop,
+			noFence: true,
+			signals: []SignalData{lat(ts+1000, fencedLat)},
+			want:    []SignalData{lat(ts+1000, fencedLat)},
+		},
+		{
+			name:       "OrphanDroppedWithGlobalFence",
+			orphans:    OrphanDrop,
+			globalOnly: true,
+			signals:    []SignalData{lat(ts+1000, fencedLat)},
+			want:       []SignalData{},
+		},
+		{
+			name:    "OrphanFollowsRedactedPair",
+			orphans: OrphanNearest,
+			signals: []SignalData{lat(ts, fencedLat), lng(ts, fencedLng), lng(ts+1000, clearLng)},
+			want:    []SignalData{lat(ts, redactedLat), lng(ts, redactedLng), lng(ts+1000, redactedLng), flag(ts, true), source(ts), count(1)},
+		},
+		{
+			name:    "OrphanInFenceNearClearPair",
+			orphans: OrphanNearest,
+			signals: []SignalData{lat(ts, clearLat), lng(ts, clearLng), lng(ts+1000, fencedLng)},
+			want:    []SignalData{lat(ts, clearLat), lng(ts, clearLng), lng(ts+1000, redactedLng), flag(ts, false), count(0)},
+		},
+		{
+			name:    "OrphanClearNearClearPair",
+			orphans: OrphanNearest,
+			signals: []SignalData{lat(ts, clearLat), lng(ts, clearLng), lat(ts+1000, clearLat+0.001)},
+			want:    []SignalData{lat(ts, clearLat), lng(ts, clearLng), lat(ts+1000, clearLat+0.001), flag(ts, false), count(0)},
+		},
+		{
+			name:    "OrphanWithoutPairDropped",
+			orphans: OrphanNearest,
+			signals: []SignalData{lat(ts, clearLat)},
+			want:    []SignalData{},
+		},
+		{
+			name:    "OrphanCoarsened",
+			orphans: OrphanCoarsen,
+			noFence: true,
+			signals: []SignalData{lat(ts, fencedLat), lng(ts+1, fencedLng)},
+			want:    []SignalData{lat(ts, 42.3), lng(ts+1, -83.7)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fence
+			if tt.noFence {
+				f = nil
+			}
+			if tt.globalOnly {
+				f = (*Fence)(nil).withGlobal(fence)
+			}
+
+			event := &StatusEventV2[StatusV2Data]{CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+				Vehicle: Vehicle{Signals: tt.signals},
+			}}}
+
+			sanitizeEventV2(event, f, ParentRedactor{}, locationOptions{
+				signals:   DefaultLocationSignals,
+				tolerance: tt.tolerance,
+				orphans:   tt.orphans,
+			})
+
+			if !reflect.DeepEqual(event.Data.Vehicle.Signals, tt.want) {
+				t.Errorf("Expected signals %+v but got %+v", tt.want, event.Data.Vehicle.Signals)
+			}
+		})
+	}
+}
+
+func TestParseOrphanPolicy(t *testing.T) {
+	if p, err := ParseOrphanPolicy(""); err != nil || p != OrphanDrop {
+		t.Errorf("Expected the default policy to be %s but got %s, %v", OrphanDrop, p, err)
+	}
+	if _, err := ParseOrphanPolicy("ignore"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+func TestCoordinate(t *testing.T) {
+	tests := []struct {
+		value any
+		want  float64
+		ok    bool
+	}{
+		{42.5, 42.5, true},
+		{float32(42.5), 42.5, true},
+		{42, 42, true},
+		{int64(-83), -83, true},
+		{uint32(7), 7, true},
+		{json.Number("-83.71"), -83.71, true},
+		{"42.26", 42.26, true},
+		{" -83.7 ", -83.7, true},
+		{"north", 0, false},
+		{json.Number("x"), 0, false},
+		{"NaN", 0, false},
+		{math.Inf(1), 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+		{map[string]any{"lat": 42.5}, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := coordinate(tt.value)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("coordinate(%#v) = %v, %t, want %v, %t", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSanitizeEventV2NonFloatCoordinates(t *testing.T) {
+	fence, _ := NewFence(FenceData{H3Indexes: []string{"872ab259effffff"}})
+
+	ts := int64(1713818407248)
+	signal := func(name string, v any) SignalData { return SignalData{Timestamp: ts, Name: name, Value: v} }
+	flag := func(v bool) SignalData { return SignalData{Timestamp: ts, Name: "IsRedacted", Value: v} }
+	source := SignalData{Timestamp: ts, Name: "redactionSource", Value: FenceSourceVehicle}
+	count := func(n float64) SignalData { return SignalData{Timestamp: ts, Name: "redactedLocationCount", Value: n} }
+
+	tests := []struct {
+		name       string
+		failClosed bool
+		signals    []SignalData
+		want       []SignalData
+	}{
+		{
+			name:    "NumericStrings",
+			signals: []SignalData{signal("latitude", "42.26172693660968"), signal("longitude", "-83.71029708818693")},
+			want:    []SignalData{signal("latitude", 42.25362819577089), signal("longitude", -83.68562802176137), flag(true), source, count(1)},
+		},
+		{
+			name:    "JSONNumbers",
+			signals: []SignalData{signal("latitude", json.Number("42.26172693660968")), signal("longitude", json.Number("-83.71029708818693"))},
+			want:    []SignalData{signal("latitude", 42.25362819577089), signal("longitude", -83.68562802176137), flag(true), source, count(1)},
+		},
+		{
+			name:    "Integers",
+			signals: []SignalData{signal("latitude", 42), signal("longitude", -83)},
+			want:    []SignalData{signal("latitude", 42), signal("longitude", -83), flag(false), count(0)},
+		},
+		{
+			name:    "UnparseablePassesThrough",
+			signals: []SignalData{signal("latitude", "north"), signal("longitude", -83.71029708818693)},
+			want:    []SignalData{signal("latitude", "north"), signal("longitude", -83.71029708818693)},
+		},
+		{
+			name:       "UnparseableFailsClosed",
+			failClosed: true,
+			signals:    []SignalData{signal("speed", 20.0), signal("latitude", "north"), signal("longitude", -83.71029708818693)},
+			want:       []SignalData{signal("speed", 20.0), flag(true), count(1)},
+		},
+		{
+			name:       "UnparseableOrphanFailsClosed",
+			failClosed: true,
+			signals:    []SignalData{signal("speed", 20.0), signal("latitude", map[string]any{})},
+			want:       []SignalData{signal("speed", 20.0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &StatusEventV2[StatusV2Data]{CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+				Vehicle: Vehicle{Signals: tt.signals},
+			}}}
+
+			sanitizeEventV2(event, fence, ParentRedactor{}, locationOptions{
+				signals:    DefaultLocationSignals,
+				failClosed: tt.failClosed,
+			})
+
+			if !reflect.DeepEqual(event.Data.Vehicle.Signals, tt.want) {
+				t.Errorf("Expected signals %+v but got %+v", tt.want, event.Data.Vehicle.Signals)
+			}
+		})
+	}
+}
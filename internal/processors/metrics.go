@@ -0,0 +1,175 @@
+package processors
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/lovoo/goka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Pipeline labels for metrics.
+const (
+	PipelineV1 = "v1"
+	PipelineV2 = "v2"
+)
+
+const namespace = "privacy_processor"
+
+var (
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Status messages consumed.",
+	}, []string{"pipeline"})
+
+	messagesEmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_emitted_total",
+		Help:      "Status messages emitted, by output topic.",
+	}, []string{"pipeline", "topic"})
+
+	locationsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "locations_total",
+		Help:      "Locations checked against fences for the main private output, by whether they were redacted.",
+	}, []string{"pipeline", "result"})
+
+	eventsWithoutFence = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_without_fence_total",
+		Help:      "Status messages for vehicles with no fence or an empty one.",
+	}, []string{"pipeline"})
+
+	decodeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decode_failures_total",
+		Help:      "Status messages that could not be decoded.",
+	}, []string{"pipeline"})
+
+	deadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Status messages that could not be processed, by reason.",
+	}, []string{"pipeline", "reason"})
+
+	signalsFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signals_filtered_total",
+		Help:      "Signals and V1 overflow keys removed by the signal filter, by the rule that removed them.",
+	}, []string{"pipeline", "rule"})
+
+	trajectoryReleases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trajectory_events_released_total",
+		Help:      "Status messages released after being held back for trajectory redaction, by what released them.",
+	}, []string{"pipeline", "reason"})
+
+	fenceCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fence_cache_lookups_total",
+		Help:      "Lookups of parsed fences, by whether the fence was cached, cached at an older version, or missing.",
+	}, []string{"pipeline", "result"})
+
+	invalidFences = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invalid_fences_total",
+		Help:      "Fences found to be invalid, counting each version of a fence once if it can be cached.",
+	}, []string{"pipeline"})
+
+	fenceCardinality = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fence_shapes",
+		Help:      "Number of cells, polygons and circles in the fences joined to status messages.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"pipeline"})
+
+	processingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processing_duration_seconds",
+		Help:      "Time spent processing a single status message.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"pipeline"})
+)
+
+// observeFence records the size of the fence joined to a message.
+func observeFence(pipeline string, fence *Fence) {
+	if fence.Empty() {
+		eventsWithoutFence.WithLabelValues(pipeline).Inc()
+		return
+	}
+	fenceCardinality.WithLabelValues(pipeline).Observe(float64(fence.size()))
+}
+
+// observeLocations records the outcome of sanitizing the main private output.
+func observeLocations(pipeline string, redacted, unredacted int) {
+	locationsProcessed.WithLabelValues(pipeline, "redacted").Add(float64(redacted))
+	locationsProcessed.WithLabelValues(pipeline, "unredacted").Add(float64(unredacted))
+}
+
+// observeDuration records the processing time of a message that started at
+// start. It is meant to be deferred.
+func observeDuration(pipeline string, start time.Time) {
+	processingDuration.WithLabelValues(pipeline).Observe(time.Since(start).Seconds())
+}
+
+// emit sends value to stream and counts it.
+func emit(ctx goka.Context, pipeline string, stream goka.Stream, value any) {
+	ctx.Emit(stream, ctx.Key(), value)
+	messagesEmitted.WithLabelValues(pipeline, string(stream)).Inc()
+}
+
+// processorStatsTimeout bounds how long a scrape waits for goka to gather
+// partition stats.
+const processorStatsTimeout = 5 * time.Second
+
+// ProcessorCollector exports the state and input lag of a goka processor.
+type ProcessorCollector struct {
+	proc *goka.Processor
+
+	state *prometheus.Desc
+	lag   *prometheus.Desc
+}
+
+// NewProcessorCollector returns a collector for proc, labelled with pipeline.
+// It must be registered by the caller.
+func NewProcessorCollector(pipeline string, proc *goka.Processor) *ProcessorCollector {
+	labels := prometheus.Labels{"pipeline": pipeline}
+	return &ProcessorCollector{
+		proc: proc,
+		state: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "processor_state"),
+			"State of the goka processor: 0 idle, 1 starting, 2 setup, 3 running, 4 stopping, 5 stopped.",
+			nil, labels),
+		lag: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "consumer_lag"),
+			"Offset lag of the goka processor on each input partition.",
+			[]string{"topic", "partition"}, labels),
+	}
+}
+
+func (c *ProcessorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.lag
+}
+
+func (c *ProcessorCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, float64(c.proc.StateReader().State()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), processorStatsTimeout)
+	defer cancel()
+
+	stats := c.proc.StatsWithContext(ctx)
+	if stats == nil {
+		return
+	}
+
+	for partition, ps := range stats.Group {
+		if ps == nil {
+			continue
+		}
+		for topic, in := range ps.Input {
+			ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, float64(in.OffsetLag), topic, strconv.Itoa(int(partition)))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package processors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+)
+
+func TestPrivacyMetrics(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := Privacy{
+		Group:        "privacy-processor",
+		StatusInput:  "topic.device.status",
+		FenceTable:   "table.device.privacyfence",
+		StatusOutput: "topic.device.status.private",
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	fencedID := "24c14Q2GGmXRT4JL0Gazu0MJ9XI"
+	unfencedID := "2fbaXmHpdQiKyAH6o5hHTCYwU0U"
+
+	gt.SetTableValue(fg.FenceTable, fencedID, &shared.CloudEvent[FenceData]{Data: FenceData{
+		H3Indexes: []string{"872ab259affffff", "872ab259effffff"},
+	}})
+
+	consumed := testutil.ToFloat64(messagesConsumed.WithLabelValues(PipelineV1))
+	emitted := testutil.ToFloat64(messagesEmitted.WithLabelValues(PipelineV1, string(fg.StatusOutput)))
+	redacted := testutil.ToFloat64(locationsProcessed.WithLabelValues(PipelineV1, "redacted"))
+	unredacted := testutil.ToFloat64(locationsProcessed.WithLabelValues(PipelineV1, "unredacted"))
+	noFence := testutil.ToFloat64(eventsWithoutFence.WithLabelValues(PipelineV1))
+
+	for _, id := range []string{fencedID, unfencedID} {
+		gt.Consume(string(fg.StatusInput), id, &shared.CloudEvent[StatusData]{Data: StatusData{
+			Latitude:  ref(42.26172693660968),
+			Longitude: ref(-83.71029708818693),
+			Overflow:  map[string]any{},
+		}})
+	}
+
+	if d := testutil.ToFloat64(messagesConsumed.WithLabelValues(PipelineV1)) - consumed; d != 2 {
+		t.Errorf("Expected 2 consumed messages but got %f", d)
+	}
+	if d := testutil.ToFloat64(messagesEmitted.WithLabelValues(PipelineV1, string(fg.StatusOutput))) - emitted; d != 2 {
+		t.Errorf("Expected 2 emitted messages but got %f", d)
+	}
+	if d := testutil.ToFloat64(locationsProcessed.WithLabelValues(PipelineV1, "redacted")) - redacted; d != 1 {
+		t.Errorf("Expected 1 redacted location but got %f", d)
+	}
+	if d := testutil.ToFloat64(locationsProcessed.WithLabelValues(PipelineV1, "unredacted")) - unredacted; d != 1 {
+		t.Errorf("Expected 1 unredacted location but got %f", d)
+	}
+	if d := testutil.ToFloat64(eventsWithoutFence.WithLabelValues(PipelineV1)) - noFence; d != 1 {
+		t.Errorf("Expected 1 event without a fence but got %f", d)
+	}
+}
+
+func TestInputCodec(t *testing.T) {
+	c := inputCodec[shared.CloudEvent[StatusData]]{PipelineV1}
+	before := testutil.ToFloat64(decodeFailures.WithLabelValues(PipelineV1))
+
+	v, err := c.Decode([]byte(`{"data": {"latitude": "north"}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error from codec: %v", err)
+	}
+	if in := v.(*inputMessage[shared.CloudEvent[StatusData]]); in.Err == nil || in.Value != nil {
+		t.Error("Expected a decode error and no value")
+	}
+
+	v, err = c.Decode([]byte(`{"data": {"latitude": 42.2}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error from codec: %v", err)
+	}
+	if in := v.(*inputMessage[shared.CloudEvent[StatusData]]); in.Err != nil || *in.Value.Data.Latitude != 42.2 {
+		t.Errorf("Unexpected decode result: %+v", in)
+	}
+
+	if d := testutil.ToFloat64(decodeFailures.WithLabelValues(PipelineV1)) - before; d != 1 {
+		t.Errorf("Expected 1 decode failure but got %f", d)
+	}
+}
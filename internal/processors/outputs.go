@@ -0,0 +1,99 @@
+package processors
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lovoo/goka"
+)
+
+// Output policies, from most to least precise.
+const (
+	// PolicyExact emits locations exactly as received. It is exempt from
+	// every fence, global fences included.
+	PolicyExact = "exact"
+	// PolicyFenced redacts locations that fall in the vehicle's fence. This is
+	// what the main status output receives.
+	PolicyFenced = "fenced"
+	// PolicyCoarse snaps every location to a coarse H3 cell. Fenced locations
+	// are redacted as PolicyFenced does if that is coarser.
+	PolicyCoarse = "coarse"
+	// PolicyDropped removes locations entirely.
+	PolicyDropped = "dropped"
+)
+
+// defaultCoarseResolution is used by PolicyCoarse when no resolution is
+// given. Cells at resolution 6 are about 36 km² in area.
+const defaultCoarseResolution = 6
+
+// Output is an additional stream that receives every status event under its
+// own policy.
+type Output struct {
+	Stream goka.Stream
+	Policy Policy
+}
+
+// Policy is the redaction policy of an Output.
+type Policy struct {
+	// Level is one of the Policy constants.
+	Level string
+	// Resolution is the H3 resolution used by PolicyCoarse.
+	Resolution int
+}
+
+// ParseOutputs parses a comma-separated list of outputs of the form
+// "topic:policy" or, for PolicyCoarse, "topic:coarse:resolution". An empty
+// string means no outputs.
+func ParseOutputs(s string) ([]Output, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var out []Output
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("output %q is not of the form topic:policy", item)
+		}
+
+		o := Output{Stream: goka.Stream(parts[0]), Policy: Policy{Level: parts[1]}}
+
+		switch o.Policy.Level {
+		case PolicyExact, PolicyFenced, PolicyDropped:
+			if len(parts) == 3 {
+				return nil, fmt.Errorf("output %q: policy %s takes no argument", item, o.Policy.Level)
+			}
+		case PolicyCoarse:
+			o.Policy.Resolution = defaultCoarseResolution
+			if len(parts) == 3 {
+				res, err := strconv.Atoi(parts[2])
+				if err != nil || res < 0 || res > 15 {
+					return nil, fmt.Errorf("output %q: invalid resolution %q", item, parts[2])
+				}
+				o.Policy.Resolution = res
+			}
+		default:
+			return nil, fmt.Errorf("output %q: unknown policy %q", item, o.Policy.Level)
+		}
+
+		out = append(out, o)
+	}
+
+	return out, nil
+}
+
+// fence returns the fence that implements the policy given the vehicle's own
+// fence, or false if locations should be left untouched.
+func (p Policy) fence(f *Fence) (*Fence, bool) {
+	switch p.Level {
+	case PolicyFenced:
+		return f, true
+	case PolicyCoarse:
+		return f.orElse(ResolutionRedactor{Resolution: p.Resolution}).coarsenedTo(p.Resolution), true
+	case PolicyDropped:
+		return (*Fence)(nil).orElse(RemoveRedactor{}), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,176 @@
+package processors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+func TestParseOutputs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Output
+		err   bool
+	}{
+		{"", nil, false},
+		{"a:exact", []Output{{Stream: "a", Policy: Policy{Level: PolicyExact}}}, false},
+		{
+			"topic.a:fenced, topic.b:coarse,topic.c:coarse:4,topic.d:dropped",
+			[]Output{
+				{Stream: "topic.a", Policy: Policy{Level: PolicyFenced}},
+				{Stream: "topic.b", Policy: Policy{Level: PolicyCoarse, Resolution: defaultCoarseResolution}},
+				{Stream: "topic.c", Policy: Policy{Level: PolicyCoarse, Resolution: 4}},
+				{Stream: "topic.d", Policy: Policy{Level: PolicyDropped}},
+			},
+			false,
+		},
+		{"a", nil, true},
+		{":exact", nil, true},
+		{"a:blurry", nil, true},
+		{"a:exact:3", nil, true},
+		{"a:coarse:16", nil, true},
+		{"a:coarse:x", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseOutputs(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseOutputs(%q): expected error %t but got %v", tt.input, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseOutputs(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPrivacyOutputs(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := Privacy{
+		Group:        "privacy-processor",
+		StatusInput:  "topic.device.status",
+		FenceTable:   "table.device.privacyfence",
+		StatusOutput: "topic.device.status.private",
+		Outputs: []Output{
+			{Stream: "topic.device.status.exact", Policy: Policy{Level: PolicyExact}},
+			{Stream: "topic.device.status.coarse", Policy: Policy{Level: PolicyCoarse, Resolution: 6}},
+			{Stream: "topic.device.status.dropped", Policy: Policy{Level: PolicyDropped}},
+		},
+		Logger: &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	fenced := gt.NewQueueTracker(string(fg.StatusOutput))
+	exact := gt.NewQueueTracker(string(fg.Outputs[0].Stream))
+	coarse := gt.NewQueueTracker(string(fg.Outputs[1].Stream))
+	dropped := gt.NewQueueTracker(string(fg.Outputs[2].Stream))
+
+	deviceID := "24c14Q2GGmXRT4JL0Gazu0MJ9XI"
+
+	gt.SetTableValue(fg.FenceTable, deviceID, &shared.CloudEvent[FenceData]{Data: FenceData{
+		H3Indexes: []string{"872ab259affffff", "872ab259effffff"},
+	}})
+
+	next := func(t *testing.T, qt *tester.QueueTracker) StatusData {
+		t.Helper()
+		key, value, valid := qt.Next()
+		if !valid {
+			t.Fatal("No output")
+		}
+		if key != deviceID {
+			t.Errorf("Expected output to maintain the device ID %s as the key, but got %s", deviceID, key)
+		}
+		return value.(*shared.CloudEvent[StatusData]).Data
+	}
+
+	t.Run("WithinFence", func(t *testing.T) {
+		gt.Consume(string(fg.StatusInput), deviceID, &shared.CloudEvent[StatusData]{Data: StatusData{
+			Latitude:  ref(42.26172693660968),
+			Longitude: ref(-83.71029708818693),
+			Overflow:  map[string]any{"odometer": 22.1},
+		}})
+
+		if d := next(t, exact); *d.Latitude != 42.26172693660968 || *d.Longitude != -83.71029708818693 || d.IsRedacted != nil {
+			t.Errorf("Expected the exact tier to be untouched, got %+v", d)
+		}
+		if d := next(t, fenced); *d.Latitude != 42.25362819577089 || *d.Longitude != -83.68562802176137 || !*d.IsRedacted {
+			t.Errorf("Expected the fenced tier to be snapped to the parent cell, got %+v", d)
+		}
+		if d := next(t, coarse); *d.Latitude != 42.25362819577089 || *d.Longitude != -83.68562802176137 || !*d.IsRedacted {
+			t.Errorf("Expected the coarse tier to use the fence redaction, got %+v", d)
+		}
+		if d := next(t, dropped); d.Latitude != nil || d.Longitude != nil || !*d.IsRedacted || d.Overflow["odometer"] != 22.1 {
+			t.Errorf("Expected the dropped tier to lose only its location, got %+v", d)
+		}
+	})
+
+	t.Run("OutsideFence", func(t *testing.T) {
+		geo := h3.NewLatLng(42.261123478313145, -83.68613574673722)
+
+		gt.Consume(string(fg.StatusInput), deviceID, &shared.CloudEvent[StatusData]{Data: StatusData{
+			Latitude:  ref(geo.Lat),
+			Longitude: ref(geo.Lng),
+			Overflow:  map[string]any{},
+		}})
+
+		if d := next(t, exact); *d.Latitude != geo.Lat || *d.Longitude != geo.Lng {
+			t.Errorf("Expected the exact tier to be untouched, got %+v", d)
+		}
+		if d := next(t, fenced); *d.Latitude != geo.Lat || *d.Longitude != geo.Lng || *d.IsRedacted {
+			t.Errorf("Expected the fenced tier to be untouched, got %+v", d)
+		}
+		want := h3.LatLngToCell(geo, 6).LatLng()
+		if d := next(t, coarse); *d.Latitude != want.Lat || *d.Longitude != want.Lng || !*d.IsRedacted {
+			t.Errorf("Expected the coarse tier to be snapped to %v, got %+v", want, d)
+		}
+		if d := next(t, dropped); d.Latitude != nil || d.Longitude != nil {
+			t.Errorf("Expected the dropped tier to have no location, got %+v", d)
+		}
+	})
+}
+
+func TestCoarsePolicyFineFence(t *testing.T) {
+	geo := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+	cell := h3.LatLngToCell(geo, 10)
+
+	tests := []struct {
+		name      string
+		redaction *Redaction
+		want      h3.LatLng
+	}{
+		{"Parent", nil, h3.LatLngToCell(geo, 6).LatLng()},
+		{"Resolution", &Redaction{Strategy: RedactResolution, Resolution: 9}, h3.LatLngToCell(geo, 6).LatLng()},
+		{"CoarserResolution", &Redaction{Strategy: RedactResolution, Resolution: 4}, h3.LatLngToCell(geo, 4).LatLng()},
+		{"Centroid", &Redaction{Strategy: RedactCentroid}, h3.LatLngToCell(cell.LatLng(), 6).LatLng()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fence, err := NewFence(FenceData{H3Indexes: []string{cell.String()}, Redaction: tt.redaction})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, _ := Policy{Level: PolicyCoarse, Resolution: 6}.fence(fence)
+			m, ok := f.Match(geo, time.Time{})
+			if !ok || m.Source != FenceSourceVehicle {
+				t.Fatalf("Expected a vehicle fence match but got %+v", m)
+			}
+			if out, ok := redact(geo, m, nil); !ok || out != tt.want {
+				t.Errorf("Expected %v but got %v", tt.want, out)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package processors
 
 import (
+	"maps"
+	"time"
+
 	"github.com/DIMO-Network/shared"
 	"github.com/lovoo/goka"
 	"github.com/rs/zerolog"
@@ -12,71 +15,214 @@ type Privacy struct {
 	StatusInput  goka.Stream
 	FenceTable   goka.Table
 	StatusOutput goka.Stream
+	// Outputs receive every status event in addition to StatusOutput, each
+	// under its own policy.
+	Outputs []Output
+	// Redactor is used for zones that don't choose their own redaction.
+	// Defaults to ParentRedactor.
+	Redactor Redactor
+	// DeadLetterOutput receives messages that can't be decoded or carry
+	// invalid coordinates. If empty, such messages are only logged.
+	DeadLetterOutput goka.Stream
+	// Filter removes overflow keys from every output. If nil, nothing is removed.
+	Filter *Filter
+	// GlobalFences apply to every vehicle in addition to FenceTable.
+	GlobalFences *GlobalFences
+	// Inheritance, if set, merges the fences of the vehicle's owner and
+	// fleet into the one from FenceTable.
+	Inheritance *Inheritance
+	// FenceLimits bounds the fences that are accepted. Zero fields use the
+	// defaults.
+	FenceLimits FenceLimits
+	// FenceErrorsOutput receives a FenceError whenever a fence that fails
+	// validation is read. If empty, invalid fences are only logged.
+	FenceErrorsOutput goka.Stream
+	// FenceFailClosed removes every location of a vehicle whose fence, or
+	// inherited fence, is invalid, instead of using the valid parts of it.
+	FenceFailClosed bool
+	// FenceHistory, if positive, is the number of versions of each vehicle's
+	// fence kept in the group table, so that events are redacted with the
+	// version that was effective at their time. See FenceData.EffectiveFrom.
+	FenceHistory int
 
 	Logger *zerolog.Logger
+
+	fences *fenceCache
 }
 
 type FenceData struct {
 	H3Indexes []string `json:"h3Indexes"`
+	// CellResolution, if set, means that H3Indexes have been compacted from
+	// cells of this resolution, as by CompactFence. Points in a coarser cell
+	// are redacted as if they had matched the cell of this resolution that
+	// contains them.
+	CellResolution int `json:"cellResolution,omitempty"`
+	// Geometries are GeoJSON Polygon or MultiPolygon objects.
+	Geometries []Geometry `json:"geometries,omitempty"`
+	Circles    []Circle   `json:"circles,omitempty"`
+	// Resolution is the H3 resolution used to redact points that fall in
+	// Geometries or Circles. Defaults to 7.
+	Resolution int `json:"resolution,omitempty"`
+	// Schedules restrict when the shapes above are active. See Zone.
+	Schedules []Schedule `json:"schedules,omitempty"`
+	// Redaction overrides the pipeline's default redaction for the shapes
+	// above.
+	Redaction *Redaction `json:"redaction,omitempty"`
+	// Companions are signals that are dropped or coarsened whenever a
+	// location in any zone is redacted.
+	Companions []Companion `json:"companions,omitempty"`
+	// Zones are additional zones, each with its own schedules and redaction.
+	Zones []Zone `json:"zones,omitempty"`
+	// TripEndpoints asks for locations around where trips start and end to
+	// be coarsened, whether or not there are any zones. It only has an
+	// effect on the V2 pipeline when PrivacyV2.Trajectory.TripEndpoints is
+	// set.
+	TripEndpoints bool `json:"tripEndpoints,omitempty"`
+	// Version identifies this version of the fence, and is recorded in the
+	// outputs redacted with it. It should increase with every edit.
+	Version int64 `json:"version,omitempty"`
+	// EffectiveFrom is when this version takes effect. Events from before
+	// then are checked against the version that was effective at their time,
+	// if the pipeline keeps a FenceHistory. Unset means at once. Without a
+	// FenceHistory it is ignored, and every version applies as soon as it is
+	// in the table.
+	EffectiveFrom *time.Time `json:"effectiveFrom,omitempty"`
 }
 
 func (g *Privacy) Define() *goka.GroupGraph {
-	return goka.DefineGroup(g.Group,
-		goka.Input(g.StatusInput, new(shared.JSONCodec[shared.CloudEvent[StatusData]]), g.processStatusEvent),
+	g.fences = newFenceCache(PipelineV1, g.FenceLimits, g.FenceErrorsOutput)
+
+	edges := []goka.Edge{
+		goka.Input(g.StatusInput, inputCodec[shared.CloudEvent[StatusData]]{PipelineV1}, g.processStatusEvent),
 		goka.Join(g.FenceTable, new(shared.JSONCodec[shared.CloudEvent[FenceData]])),
 		goka.Output(g.StatusOutput, new(shared.JSONCodec[shared.CloudEvent[StatusData]])),
-	)
+	}
+
+	for _, o := range g.Outputs {
+		edges = append(edges, goka.Output(o.Stream, new(shared.JSONCodec[shared.CloudEvent[StatusData]])))
+	}
+
+	if g.DeadLetterOutput != "" {
+		edges = append(edges, goka.Output(g.DeadLetterOutput, new(shared.JSONCodec[shared.CloudEvent[DeadLetter]])))
+	}
+
+	if g.FenceErrorsOutput != "" {
+		edges = append(edges, goka.Output(g.FenceErrorsOutput, new(shared.JSONCodec[shared.CloudEvent[FenceError]])))
+	}
+
+	edges = append(edges, g.Inheritance.edges()...)
+
+	if g.FenceHistory > 0 {
+		edges = append(edges, goka.Persist(new(shared.JSONCodec[vehicleState])))
+	}
+
+	return goka.DefineGroup(g.Group, edges...)
 }
 
 func (g *Privacy) processStatusEvent(ctx goka.Context, msg interface{}) {
-	fence := getFence(ctx, g.FenceTable)
-	event := msg.(*shared.CloudEvent[StatusData])
+	defer observeDuration(PipelineV1, time.Now())
+	messagesConsumed.WithLabelValues(PipelineV1).Inc()
+
+	in := msg.(*inputMessage[shared.CloudEvent[StatusData]])
+	if in.Err != nil {
+		deadLetter(ctx, PipelineV1, g.DeadLetterOutput, g.Logger, ReasonDecodeError, in.Raw, in.Err)
+		return
+	}
+	event := in.Value
+	if err := validateStatus(&event.Data); err != nil {
+		deadLetter(ctx, PipelineV1, g.DeadLetterOutput, g.Logger, ReasonInvalidLocation, in.Raw, err)
+		return
+	}
 
-	sanitizeEvent(event, fence)
+	g.Filter.filterOverflow(PipelineV1, &event.Data)
+
+	var state *vehicleState
+	if g.FenceHistory > 0 {
+		state = stateValue(ctx)
+		current, _ := ctx.Join(g.FenceTable).(*shared.CloudEvent[FenceData])
+		if state.recordFence(current, g.FenceHistory) {
+			saveState(ctx, state)
+		}
+	}
+
+	own, version, err := vehicleFence(ctx, g.FenceTable, g.fences, state, event.Time)
+	fence, err := g.Inheritance.fence(ctx, own, err, g.fences)
+	fence = checkFence(ctx, g.Logger, fence, err, g.FenceFailClosed)
+	observeFence(PipelineV1, fence)
+	fence = fence.withGlobal(g.GlobalFences.Fence())
+
+	event.Data.FenceVersion = version
+	// Only the processor may say what redacted a location, even in the exact
+	// tier.
+	event.Data.RedactionSource = ""
+
+	// Every tier is derived from the same consumed message.
+	for _, o := range g.Outputs {
+		out := copyEvent(event)
+		if f, ok := o.Policy.fence(fence); ok {
+			sanitizeEvent(out, f, g.Redactor)
+		}
+		emit(ctx, PipelineV1, o.Stream, out)
+	}
+
+	sanitizeEvent(event, fence, g.Redactor)
+	if event.Data.IsRedacted != nil {
+		if *event.Data.IsRedacted {
+			observeLocations(PipelineV1, 1, 0)
+		} else {
+			observeLocations(PipelineV1, 0, 1)
+		}
+	}
 
 	// Key should be the DIMO device id.
-	ctx.Emit(g.StatusOutput, ctx.Key(), event)
+	emit(ctx, PipelineV1, g.StatusOutput, event)
 }
 
-// sanitizeEvent modifies the given CloudEvent using fence.
-func sanitizeEvent(event *shared.CloudEvent[StatusData], fence []h3.Cell) {
+// copyEvent returns a copy of event that can be sanitized independently.
+func copyEvent(event *shared.CloudEvent[StatusData]) *shared.CloudEvent[StatusData] {
+	out := *event
+	// StatusData.MarshalJSON writes into the overflow map.
+	out.Data.Overflow = maps.Clone(event.Data.Overflow)
+	return &out
+}
+
+// sanitizeEvent modifies the given CloudEvent using fence. Points in zones
+// without their own redaction are redacted by redactor. RedactionSource is
+// always replaced.
+func sanitizeEvent(event *shared.CloudEvent[StatusData], fence *Fence, redactor Redactor) {
+	event.Data.RedactionSource = ""
+
 	if event.Data.Latitude == nil || event.Data.Longitude == nil {
 		return
 	}
 
 	geo := h3.NewLatLng(*event.Data.Latitude, *event.Data.Longitude)
 
-	for _, fenceInd := range fence {
-		// TODO: Should really validate res more.
-		res := fenceInd.Resolution()
-		// TODO: Cache these.
-		statusInd := h3.LatLngToCell(geo, res)
-		if statusInd == fenceInd {
-			outGeo := statusInd.Parent(res - 1).LatLng()
-
+	if m, ok := fence.Match(geo, event.Time); ok {
+		if outGeo, ok := redact(geo, m, redactor); ok {
 			event.Data.Latitude, event.Data.Longitude = &outGeo.Lat, &outGeo.Lng
-			event.Data.IsRedacted = ref(true)
-
-			return
+		} else {
+			event.Data.Latitude, event.Data.Longitude = nil, nil
 		}
+		redactCompanionKeys(&event.Data, m.Companions)
+		event.Data.IsRedacted = ref(true)
+		event.Data.RedactionSource = m.Source
+
+		return
 	}
 
 	event.Data.IsRedacted = ref(false)
 }
 
-func getFence(ctx goka.Context, fenceTable goka.Table) []h3.Cell {
+// getFence returns the matcher for the fence joined to the current message,
+// or nil if there is none. See NewFence for the error semantics.
+func getFence(ctx goka.Context, fenceTable goka.Table, cache *fenceCache) (*Fence, error) {
 	val := ctx.Join(fenceTable)
 	if val == nil {
-		return nil
-	}
-
-	sIndexes := val.(*shared.CloudEvent[FenceData]).Data.H3Indexes
-	out := make([]h3.Cell, len(sIndexes))
-	for i, s := range sIndexes {
-		out[i] = h3.Cell(h3.IndexFromString(s))
+		return nil, nil
 	}
 
-	return out
+	return readFence(ctx, cache, fenceTable, ctx.Key(), val.(*shared.CloudEvent[FenceData]))
 }
 
 func ref[A any](a A) *A {
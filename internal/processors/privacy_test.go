@@ -92,4 +92,81 @@ func TestPrivacy(t *testing.T) {
 		}
 	})
 
+	t.Run("WithinPolygonFence", func(t *testing.T) {
+		polygonDeviceID := "2fbaXmHpdQiKyAH6o5hHTCYwU0U"
+
+		gt.SetTableValue(fg.FenceTable, polygonDeviceID, &shared.CloudEvent[FenceData]{Data: FenceData{
+			Geometries: []Geometry{{
+				Type:        "Polygon",
+				Coordinates: []byte(`[[[-83.72, 42.25], [-83.70, 42.25], [-83.70, 42.27], [-83.72, 42.27], [-83.72, 42.25]]]`),
+			}},
+		}})
+
+		gt.Consume(string(fg.StatusInput), polygonDeviceID, &shared.CloudEvent[StatusData]{Data: StatusData{
+			Latitude:  ref(42.26172693660968),
+			Longitude: ref(-83.71029708818693),
+			Overflow:  map[string]interface{}{},
+		}})
+
+		_, value, valid := out.Next()
+		if !valid {
+			t.Fatal("No output")
+		}
+
+		event := value.(*shared.CloudEvent[StatusData])
+		if *event.Data.Latitude != 42.25362819577089 || *event.Data.Longitude != -83.68562802176137 {
+			t.Errorf("Expected %f, %f in the output but got %f, %f",
+				42.25362819577089, -83.68562802176137,
+				*event.Data.Latitude, *event.Data.Longitude,
+			)
+		}
+
+		if *event.Data.IsRedacted != true {
+			t.Errorf("Expected isRedacted to be true")
+		}
+	})
+}
+
+func TestPrivacyRedactionSourceReplaced(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := Privacy{
+		Group:        "privacy-processor",
+		StatusInput:  "topic.device.status",
+		FenceTable:   "table.device.privacyfence",
+		StatusOutput: "topic.device.status.private",
+		Outputs:      []Output{{Stream: "topic.device.status.exact", Policy: Policy{Level: PolicyExact}}},
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	exact := gt.NewQueueTracker(string(fg.Outputs[0].Stream))
+
+	deviceID := "24c14Q2GGmXRT4JL0Gazu0MJ9XI"
+
+	gt.SetTableValue(fg.FenceTable, deviceID, &shared.CloudEvent[FenceData]{Data: FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+	}})
+
+	gt.Consume(string(fg.StatusInput), deviceID, &shared.CloudEvent[StatusData]{Data: StatusData{
+		Latitude:        ref(42.261123478313145),
+		Longitude:       ref(-83.68613574673722),
+		RedactionSource: FenceSourceGlobal,
+		Overflow:        map[string]any{},
+	}})
+
+	for _, tracker := range []*tester.QueueTracker{out, exact} {
+		_, value, ok := tracker.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+		if data := value.(*shared.CloudEvent[StatusData]).Data; data.RedactionSource != "" {
+			t.Errorf("Expected the upstream redaction source to be cleared but got %+v", data)
+		}
+	}
 }
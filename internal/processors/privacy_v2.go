@@ -1,6 +1,12 @@
 package processors
 
 import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/DIMO-Network/privacy-processor/pkg/attestation"
 	"github.com/DIMO-Network/shared"
 	"github.com/lovoo/goka"
 	"github.com/rs/zerolog"
@@ -12,76 +18,374 @@ type PrivacyV2 struct {
 	StatusInput  goka.Stream
 	FenceTable   goka.Table
 	StatusOutput goka.Stream
+	// Outputs receive every status event in addition to StatusOutput, each
+	// under its own policy.
+	Outputs []Output
+	// Redactor is used for zones that don't choose their own redaction.
+	// Defaults to ParentRedactor.
+	Redactor Redactor
+	// DeadLetterOutput receives messages that can't be decoded or carry
+	// invalid coordinates. If empty, such messages are only logged.
+	DeadLetterOutput goka.Stream
+	// Filter removes signals from every output. If nil, nothing is removed.
+	Filter *Filter
+	// LocationSignals lists the signals that carry locations. Defaults to
+	// DefaultLocationSignals.
+	LocationSignals []LocationSignals
+	// PairTolerance is the largest difference between the timestamps of a
+	// latitude and longitude that are paired. Zero requires equal timestamps.
+	PairTolerance time.Duration
+	// OrphanPolicy handles coordinates that could not be paired. Defaults to
+	// OrphanDrop.
+	OrphanPolicy string
+	// FailClosed removes coordinates that can't be read as numbers instead
+	// of passing them through.
+	FailClosed bool
+	// Scrubber removes or pseudonymizes device identifiers. If nil, the
+	// device block and extensions are passed through.
+	Scrubber *Scrubber
+	// Signer signs the data of every emitted event. If nil, events are
+	// emitted unsigned.
+	Signer *attestation.Signer
+	// GlobalFences apply to every vehicle in addition to FenceTable.
+	GlobalFences *GlobalFences
+	// Inheritance, if set, merges the fences of the vehicle's owner and
+	// fleet into the one from FenceTable.
+	Inheritance *Inheritance
+	// FenceLimits bounds the fences that are accepted. Zero fields use the
+	// defaults.
+	FenceLimits FenceLimits
+	// FenceErrorsOutput receives a FenceError whenever a fence that fails
+	// validation is read. If empty, invalid fences are only logged.
+	FenceErrorsOutput goka.Stream
+	// FenceFailClosed removes every location of a vehicle whose fence, or
+	// inherited fence, is invalid, instead of using the valid parts of it.
+	FenceFailClosed bool
+	// FenceHistory, if positive, is the number of versions of each vehicle's
+	// fence kept in the group table, so that events are redacted with the
+	// version that was effective at their time. See FenceData.EffectiveFrom.
+	FenceHistory int
+	// Trajectory, if set, also redacts locations just before entering and
+	// after leaving a zone. Events are then held back in the group table.
+	Trajectory *Trajectory
 
 	Logger *zerolog.Logger
+
+	fences *fenceCache
 }
 
 func (g *PrivacyV2) DefineV2() *goka.GroupGraph {
-	return goka.DefineGroup(g.Group,
-		goka.Input(g.StatusInput, new(shared.JSONCodec[StatusEventV2[StatusV2Data]]), g.processStatusEventV2),
+	g.fences = newFenceCache(PipelineV2, g.FenceLimits, g.FenceErrorsOutput)
+
+	edges := []goka.Edge{
+		goka.Input(g.StatusInput, inputCodec[StatusEventV2[StatusV2Data]]{PipelineV2}, g.processStatusEventV2),
 		goka.Join(g.FenceTable, new(shared.JSONCodec[shared.CloudEvent[FenceData]])),
 		goka.Output(g.StatusOutput, new(shared.JSONCodec[StatusEventV2[StatusV2Data]])),
-	)
+	}
+
+	for _, o := range g.Outputs {
+		edges = append(edges, goka.Output(o.Stream, new(shared.JSONCodec[StatusEventV2[StatusV2Data]])))
+	}
+
+	if g.DeadLetterOutput != "" {
+		edges = append(edges, goka.Output(g.DeadLetterOutput, new(shared.JSONCodec[shared.CloudEvent[DeadLetter]])))
+	}
+
+	if g.FenceErrorsOutput != "" {
+		edges = append(edges, goka.Output(g.FenceErrorsOutput, new(shared.JSONCodec[shared.CloudEvent[FenceError]])))
+	}
+
+	edges = append(edges, g.Inheritance.edges()...)
+
+	if g.Trajectory != nil || g.FenceHistory > 0 {
+		edges = append(edges, goka.Persist(new(shared.JSONCodec[vehicleState])))
+	}
+	if g.Trajectory != nil {
+		edges = append(edges, goka.Visitor(trajectoryVisitor, g.flushTrajectory))
+	}
+
+	return goka.DefineGroup(g.Group, edges...)
+}
+
+// FlushTrajectories releases the events that have been held back for Delay
+// without the vehicle reporting anything newer. It should be called
+// periodically on the processor running the graph from DefineV2, and does
+// nothing if Trajectory is unset.
+func (g *PrivacyV2) FlushTrajectories(ctx context.Context, p *goka.Processor, now time.Time) error {
+	if g.Trajectory == nil {
+		return nil
+	}
+	return p.VisitAll(ctx, trajectoryVisitor, now)
 }
 
 func (g *PrivacyV2) processStatusEventV2(ctx goka.Context, msg interface{}) {
-	fence := getFence(ctx, g.FenceTable)
-	event := msg.(*StatusEventV2[StatusV2Data])
+	defer observeDuration(PipelineV2, time.Now())
+	messagesConsumed.WithLabelValues(PipelineV2).Inc()
+
+	in := msg.(*inputMessage[StatusEventV2[StatusV2Data]])
+	if in.Err != nil {
+		deadLetter(ctx, PipelineV2, g.DeadLetterOutput, g.Logger, ReasonDecodeError, in.Raw, in.Err)
+		return
+	}
+	event := in.Value
+	opts := g.locationOptions()
+	if err := validateStatusV2(&event.Data, opts.signals); err != nil {
+		deadLetter(ctx, PipelineV2, g.DeadLetterOutput, g.Logger, ReasonInvalidLocation, in.Raw, err)
+		return
+	}
 
-	sanitizeEventV2(event, fence)
+	var state *vehicleState
+	changed := false
+	if g.Trajectory != nil || g.FenceHistory > 0 {
+		state = stateValue(ctx)
+	}
+	if g.FenceHistory > 0 {
+		current, _ := ctx.Join(g.FenceTable).(*shared.CloudEvent[FenceData])
+		changed = state.recordFence(current, g.FenceHistory)
+	}
+
+	fence, version := g.fence(ctx, state, event.Time)
+	observeFence(PipelineV2, fence)
+	fence = fence.withGlobal(g.GlobalFences.Fence())
+
+	if g.Trajectory == nil {
+		g.Filter.filterSignals(PipelineV2, event)
+		g.publish(ctx, event, fence, version, opts)
+		if changed {
+			saveState(ctx, state)
+		}
+		return
+	}
+
+	// Trip endpoints are found from signals, such as the ignition, that the
+	// filter may remove, so it only runs once the event is held.
+	state.hold(event, g.Trajectory, fence, g.Redactor, opts, time.Now())
+	g.Filter.filterSignals(PipelineV2, event)
+	g.release(ctx, state, state.due(PipelineV2, g.Trajectory), state.Newest, true)
+}
+
+// flushTrajectory is the visitor callback for FlushTrajectories. msg is the
+// current time.
+func (g *PrivacyV2) flushTrajectory(ctx goka.Context, msg interface{}) {
+	now := msg.(time.Time)
+	state := stateValue(ctx)
+	events := state.expired(PipelineV2, g.Trajectory, now)
+
+	// With nothing held, the vehicle has gone quiet and anchors can age out
+	// by the clock.
+	newest := state.Newest
+	if len(state.Held) == 0 {
+		newest = max(newest, now.UnixMilli())
+	}
+	g.release(ctx, state, events, newest, false)
+}
+
+// release publishes events that were held back, each with the fence that was
+// effective at its time, and saves what is left of the vehicle's state if it
+// changed. changed tells whether the caller already changed it.
+func (g *PrivacyV2) release(ctx goka.Context, state *vehicleState, events []*StatusEventV2[StatusV2Data], newest int64, changed bool) {
+	opts := g.locationOptions()
+	opts.nearZone = state.nearZone(g.Trajectory)
+	for _, event := range events {
+		fence, version := g.fence(ctx, state, event.Time)
+		g.publish(ctx, event, fence.withGlobal(g.GlobalFences.Fence()), version, opts)
+	}
+
+	// Flushes visit every vehicle, most of which have nothing to release.
+	if state.prune(g.Trajectory, newest) || changed || len(events) != 0 {
+		saveState(ctx, state)
+	}
+}
+
+// fence returns the fence of the vehicle of the current message that was
+// effective at t, merged with any inherited ones, and its version.
+func (g *PrivacyV2) fence(ctx goka.Context, state *vehicleState, t time.Time) (*Fence, int64) {
+	own, version, err := vehicleFence(ctx, g.FenceTable, g.fences, state, t)
+	fence, err := g.Inheritance.fence(ctx, own, err, g.fences)
+	return checkFence(ctx, g.Logger, fence, err, g.FenceFailClosed), version
+}
+
+// publish redacts event for every output and emits it. version is that of
+// the vehicle's fence, and is stamped into every output.
+func (g *PrivacyV2) publish(ctx goka.Context, event *StatusEventV2[StatusV2Data], fence *Fence, version int64, opts locationOptions) {
+	removeRedactionSources(event)
+
+	// Every tier is derived from the same consumed message.
+	for _, o := range g.Outputs {
+		out := copyEventV2(event)
+		if f, ok := o.Policy.fence(fence); ok {
+			sanitizeEventV2(out, f, g.Redactor, opts)
+		}
+		g.Scrubber.scrub(out)
+		stampFenceVersion(out, version)
+		g.attest(ctx, out)
+		emit(ctx, PipelineV2, o.Stream, out)
+	}
+
+	redacted, unredacted := sanitizeEventV2(event, fence, g.Redactor, opts)
+	observeLocations(PipelineV2, redacted, unredacted)
+	g.Scrubber.scrub(event)
+	stampFenceVersion(event, version)
+	g.attest(ctx, event)
 
 	// Key should be the DIMO vehicle token id.
-	ctx.Emit(g.StatusOutput, ctx.Key(), event)
+	emit(ctx, PipelineV2, g.StatusOutput, event)
 }
 
-// sanitizeEventV2 modifies the given CloudEvent using fence.
-func sanitizeEventV2(event *StatusEventV2[StatusV2Data], fence []h3.Cell) {
-	locationIndexesByTimestamp := findIndexForLocationPairsWithSameTimestamp(event.Data.Vehicle.Signals)
+// redactedLocationCountSignal is the name of the per-event summary signal.
+const redactedLocationCountSignal = "redactedLocationCount"
+
+// redactionSourceSignal tells which FenceSource caused the redaction of the
+// locations at its timestamp.
+const redactionSourceSignal = "redactionSource"
+
+func (g *PrivacyV2) locationOptions() locationOptions {
+	opts := locationOptions{
+		signals:    g.LocationSignals,
+		tolerance:  g.PairTolerance.Milliseconds(),
+		orphans:    g.OrphanPolicy,
+		failClosed: g.FailClosed,
+	}
+	if len(opts.signals) == 0 {
+		opts.signals = DefaultLocationSignals
+	}
+	return opts
+}
 
-	if len(locationIndexesByTimestamp) == 0 {
+// attest replaces the signature of the received event, which no longer
+// matches the data, with our own over the whole event. The original is kept
+// in OriginalSignature. Without a Signer, the event is left as it is. It must
+// be the last change to the event.
+func (g *PrivacyV2) attest(ctx goka.Context, event *StatusEventV2[StatusV2Data]) {
+	if g.Signer == nil {
 		return
 	}
 
-	for _, signals := range locationIndexesByTimestamp {
-		latitudeIndx, ok := signals["latitude"]
-		if !ok {
-			continue
-		}
+	event.OriginalSignature = event.Signature
+	event.Signature = ""
 
-		longitudeIndx, ok := signals["longitude"]
-		if !ok {
-			continue
-		}
+	b, err := json.Marshal(event)
+	if err != nil {
+		g.Logger.Err(err).Str("key", ctx.Key()).Msg("Couldn't encode event for signing, emitting it unsigned.")
+		return
+	}
 
-		latVal, ok := event.Data.Vehicle.Signals[latitudeIndx].Value.(float64)
-		if !ok {
-			continue
-		}
+	sig, err := g.Signer.SignEvent(b)
+	if err != nil {
+		g.Logger.Err(err).Str("key", ctx.Key()).Msg("Couldn't sign event, emitting it unsigned.")
+		return
+	}
+	event.Signature = sig
+}
 
-		lngVal, ok := event.Data.Vehicle.Signals[longitudeIndx].Value.(float64)
-		if !ok {
-			continue
-		}
+// copyEventV2 returns a copy of event that can be sanitized independently.
+func copyEventV2(event *StatusEventV2[StatusV2Data]) *StatusEventV2[StatusV2Data] {
+	out := *event
+	out.Data.Vehicle.Signals = slices.Clone(event.Data.Vehicle.Signals)
+	return &out
+}
 
-		geo := h3.NewLatLng(latVal, lngVal)
+// sanitizeEventV2 modifies the given CloudEvent using fence. Every pair of
+// location signals is checked, and points in zones without their own
+// redaction are redacted by redactor. It returns the number of location pairs
+// that were redacted and left in the clear.
+func sanitizeEventV2(event *StatusEventV2[StatusV2Data], fence *Fence, redactor Redactor, opts locationOptions) (redacted, unredacted int) {
+	pairs, orphans := pairLocations(event.Data.Vehicle.Signals, opts.signals, opts.tolerance)
 
-		for _, fenceInd := range fence {
-			res := fenceInd.Resolution()
-			statusInd := h3.LatLngToCell(geo, res)
-			if statusInd == fenceInd {
-				outGeo := statusInd.Parent(res - 1).LatLng()
+	var drop []int
+	var decisions []pairDecision
 
-				event.Data.Vehicle.Signals[latitudeIndx].Value = outGeo.Lat
-				event.Data.Vehicle.Signals[longitudeIndx].Value = outGeo.Lng
+	// A timestamp is redacted if any of its pairs is, and its source is
+	// that of the first.
+	var timestamps []int64
+	isRedacted := make(map[int64]bool)
+	sources := make(map[int64]string)
 
-				addIsRedactedSignal(event, event.Data.Vehicle.Signals[latitudeIndx].Timestamp, true)
+	for _, p := range pairs {
+		d := pairDecision{timestamp: p.timestamp}
 
-				return
+		latVal, latOK := coordinate(event.Data.Vehicle.Signals[p.lat].Value)
+		lngVal, lngOK := coordinate(event.Data.Vehicle.Signals[p.lng].Value)
+		if !latOK || !lngOK {
+			if !opts.failClosed {
+				continue
 			}
+			drop = append(drop, p.lat, p.lng)
+			drop = append(drop, p.companions...)
+			d.redacted, d.removed = true, true
+		} else {
+			geo := h3.NewLatLng(latVal, lngVal)
+			d.in = geo
+
+			if m, ok := fence.Match(geo, signalTime(event, p.timestamp)); ok {
+				d.redacted, d.source = true, m.Source
+				if outGeo, ok := redact(geo, m, redactor); ok {
+					event.Data.Vehicle.Signals[p.lat].Value = outGeo.Lat
+					event.Data.Vehicle.Signals[p.lng].Value = outGeo.Lng
+					d.out = outGeo
+				} else {
+					drop = append(drop, p.lat, p.lng)
+					d.removed = true
+				}
+				drop = append(drop, p.companions...)
+				drop = append(drop, redactCompanionSignals(event, m.Companions, p.timestamp, opts.tolerance)...)
+			} else if a, ok := opts.near(geo, signalTime(event, p.timestamp)); ok {
+				d.redacted, d.source = true, a.Source
+				if a.Removed {
+					drop = append(drop, p.lat, p.lng)
+					d.removed = true
+				} else {
+					event.Data.Vehicle.Signals[p.lat].Value = a.OutLatitude
+					event.Data.Vehicle.Signals[p.lng].Value = a.OutLongitude
+					d.out = h3.NewLatLng(a.OutLatitude, a.OutLongitude)
+				}
+				drop = append(drop, p.companions...)
+			}
+		}
+
+		decisions = append(decisions, d)
+		if d.redacted {
+			redacted++
+		} else {
+			unredacted++
 		}
 
-		addIsRedactedSignal(event, event.Data.Vehicle.Signals[latitudeIndx].Timestamp, false)
+		if _, ok := isRedacted[p.timestamp]; !ok {
+			timestamps = append(timestamps, p.timestamp)
+		}
+		isRedacted[p.timestamp] = isRedacted[p.timestamp] || d.redacted
+		if sources[p.timestamp] == "" {
+			sources[p.timestamp] = d.source
+		}
 	}
+
+	for _, ts := range timestamps {
+		addIsRedactedSignal(event, ts, isRedacted[ts])
+		if sources[ts] != "" {
+			event.Data.Vehicle.Signals = append(event.Data.Vehicle.Signals, SignalData{
+				Timestamp: ts,
+				Name:      redactionSourceSignal,
+				Value:     sources[ts],
+			})
+		}
+	}
+	if len(decisions) > 0 {
+		addRedactedLocationCountSignal(event, redacted, decisions[0].timestamp)
+	}
+
+	drop = append(drop, sanitizeOrphans(event, orphans, decisions, fence, redactor, opts)...)
+	removeSignals(event, drop...)
+
+	return
+}
+
+// signalTime converts a signal timestamp to a time, falling back to the event
+// time for signals without one.
+func signalTime(event *StatusEventV2[StatusV2Data], timestamp int64) time.Time {
+	if timestamp == 0 {
+		return event.Time
+	}
+	return time.UnixMilli(timestamp)
 }
 
 func addIsRedactedSignal(event *StatusEventV2[StatusV2Data], timestamp int64, isRedacted bool) {
@@ -96,18 +400,51 @@ func addIsRedactedSignal(event *StatusEventV2[StatusV2Data], timestamp int64, is
 	event.Data.Vehicle.Signals = append(event.Data.Vehicle.Signals, isRedactedSignal)
 }
 
-// findIndexForLocationPairsWithSameTimestamp returns a map of timestamps to a map of signal names(long and lat ) to their index in the slice
-func findIndexForLocationPairsWithSameTimestamp(signals []SignalData) map[int64]map[string]int {
-	result := make(map[int64]map[string]int)
-
-	for i, signal := range signals {
-		if signal.Name == "longitude" || signal.Name == "latitude" {
-			if _, ok := result[signal.Timestamp]; !ok {
-				result[signal.Timestamp] = make(map[string]int)
-			}
-			result[signal.Timestamp][signal.Name] = i
+// removeRedactionSources removes the redactionSource signals that the event
+// arrived with. Only the processor may say what redacted a location, even in
+// the exact tier.
+func removeRedactionSources(event *StatusEventV2[StatusV2Data]) {
+	var drop []int
+	for i, s := range event.Data.Vehicle.Signals {
+		if s.Name == redactionSourceSignal {
+			drop = append(drop, i)
 		}
 	}
+	removeSignals(event, drop...)
+}
+
+// addRedactedLocationCountSignal records how many location pairs in the event
+// were redacted. It is stamped with the time of the event, or fallback if the
+// event has none.
+func addRedactedLocationCountSignal(event *StatusEventV2[StatusV2Data], count int, fallback int64) {
+	timestamp := event.Data.Timestamp
+	if timestamp == 0 && !event.Time.IsZero() {
+		timestamp = event.Time.UnixMilli()
+	}
+	if timestamp == 0 {
+		timestamp = fallback
+	}
 
-	return result
+	event.Data.Vehicle.Signals = append(event.Data.Vehicle.Signals, SignalData{
+		Timestamp: timestamp,
+		Name:      redactedLocationCountSignal,
+		Value:     float64(count),
+	})
+}
+
+// removeSignals deletes the signals at the given indexes, preserving the order
+// of the rest.
+func removeSignals(event *StatusEventV2[StatusV2Data], indexes ...int) {
+	drop := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		drop[i] = true
+	}
+
+	kept := event.Data.Vehicle.Signals[:0]
+	for i, signal := range event.Data.Vehicle.Signals {
+		if !drop[i] {
+			kept = append(kept, signal)
+		}
+	}
+	event.Data.Vehicle.Signals = kept
 }
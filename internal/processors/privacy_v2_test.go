@@ -1,12 +1,17 @@
 package processors
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"os"
+	"reflect"
+	"slices"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/DIMO-Network/privacy-processor/pkg/attestation"
 	"github.com/DIMO-Network/shared"
 	"github.com/lovoo/goka"
 	"github.com/lovoo/goka/tester"
@@ -290,4 +295,325 @@ func TestPrivacyV2(t *testing.T) {
 			t.Errorf("Expected isRedacted to be true")
 		}
 	})
+
+	t.Run("ScheduledZoneUsesSignalTime", func(t *testing.T) {
+		scheduledTokenID := "4444"
+
+		// Active on weekdays from 8:00 to 18:00 in Detroit. The first pair is
+		// on a Monday at 16:40 local time and the second on a Saturday.
+		gt.SetTableValue(fg.FenceTable, scheduledTokenID, &shared.CloudEvent[FenceData]{Data: FenceData{
+			Zones: []Zone{{
+				H3Indexes: []string{"872ab259affffff", "872ab259effffff"},
+				Schedules: []Schedule{{
+					Days:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+					Start:    "08:00",
+					End:      "18:00",
+					Timezone: "America/Detroit",
+				}},
+			}},
+		}})
+
+		statusV2 := StatusEventV2[StatusV2Data]{
+			CloudEvent: shared.CloudEvent[StatusV2Data]{
+				Data: StatusV2Data{
+					Timestamp: 1713818407248,
+					Vehicle: Vehicle{
+						Signals: []SignalData{
+							{Timestamp: 1713818407248, Name: "latitude", Value: 42.26172693660968},
+							{Timestamp: 1713818407248, Name: "longitude", Value: -83.71029708818693},
+						},
+					},
+				},
+			},
+		}
+
+		gt.Consume(string(fg.StatusInput), scheduledTokenID, &statusV2)
+
+		_, value, valid := out.Next()
+		if !valid {
+			t.Fatal("No output")
+		}
+
+		event := value.(*StatusEventV2[StatusV2Data])
+		if event.Data.Vehicle.Signals[0].Value != 42.25362819577089 || event.Data.Vehicle.Signals[2].Value != true {
+			t.Errorf("Expected the weekday reading to be redacted")
+		}
+
+		saturday := int64(1713629407248)
+		statusV2.Data.Vehicle.Signals = []SignalData{
+			{Timestamp: saturday, Name: "latitude", Value: 42.26172693660968},
+			{Timestamp: saturday, Name: "longitude", Value: -83.71029708818693},
+		}
+
+		gt.Consume(string(fg.StatusInput), scheduledTokenID, &statusV2)
+
+		_, value, valid = out.Next()
+		if !valid {
+			t.Fatal("No output")
+		}
+
+		event = value.(*StatusEventV2[StatusV2Data])
+		if event.Data.Vehicle.Signals[0].Value != 42.26172693660968 || event.Data.Vehicle.Signals[2].Value != false {
+			t.Errorf("Expected the weekend reading to pass through")
+		}
+	})
+}
+
+func TestSanitizeEventV2EveryPair(t *testing.T) {
+	fence, _ := NewFence(FenceData{H3Indexes: []string{"872ab259effffff"}})
+
+	const (
+		t1 = int64(1713818400000)
+		t2 = int64(1713818401000)
+		t3 = int64(1713818402000)
+
+		fencedLat   = 42.26172693660968
+		fencedLng   = -83.71029708818693
+		redactedLat = 42.25362819577089
+		redactedLng = -83.68562802176137
+		clearLat    = 42.261123478313145
+		clearLng    = -83.68613574673722
+	)
+
+	signal := func(ts int64, name string, v any) SignalData { return SignalData{Timestamp: ts, Name: name, Value: v} }
+	fencedPair := func(ts int64) []SignalData {
+		return []SignalData{signal(ts, "latitude", fencedLat), signal(ts, "longitude", fencedLng)}
+	}
+	redactedPair := func(ts int64) []SignalData {
+		return []SignalData{signal(ts, "latitude", redactedLat), signal(ts, "longitude", redactedLng)}
+	}
+	clearPair := func(ts int64) []SignalData {
+		return []SignalData{signal(ts, "latitude", clearLat), signal(ts, "longitude", clearLng)}
+	}
+	flag := func(ts int64, v bool) SignalData { return signal(ts, "IsRedacted", v) }
+	source := func(ts int64) SignalData { return signal(ts, "redactionSource", FenceSourceVehicle) }
+	count := func(n float64) SignalData { return signal(t1, "redactedLocationCount", n) }
+
+	tests := []struct {
+		name       string
+		signals    []SignalData
+		want       []SignalData
+		redacted   int
+		unredacted int
+	}{
+		{
+			name:       "FencedThenClear",
+			signals:    slices.Concat(fencedPair(t1), clearPair(t2)),
+			want:       slices.Concat(redactedPair(t1), clearPair(t2), []SignalData{flag(t1, true), source(t1), flag(t2, false), count(1)}),
+			redacted:   1,
+			unredacted: 1,
+		},
+		{
+			name:       "ClearThenFenced",
+			signals:    slices.Concat(clearPair(t1), fencedPair(t2)),
+			want:       slices.Concat(clearPair(t1), redactedPair(t2), []SignalData{flag(t1, false), flag(t2, true), source(t2), count(1)}),
+			redacted:   1,
+			unredacted: 1,
+		},
+		{
+			name:       "FencedClearFenced",
+			signals:    slices.Concat(fencedPair(t1), clearPair(t2), fencedPair(t3)),
+			want:       slices.Concat(redactedPair(t1), clearPair(t2), redactedPair(t3), []SignalData{flag(t1, true), source(t1), flag(t2, false), flag(t3, true), source(t3), count(2)}),
+			redacted:   2,
+			unredacted: 1,
+		},
+		{
+			name:       "Interleaved",
+			signals:    []SignalData{fencedPair(t1)[0], clearPair(t2)[0], clearPair(t2)[1], fencedPair(t1)[1]},
+			want:       []SignalData{redactedPair(t1)[0], clearPair(t2)[0], clearPair(t2)[1], redactedPair(t1)[1], flag(t1, true), source(t1), flag(t2, false), count(1)},
+			redacted:   1,
+			unredacted: 1,
+		},
+		{
+			name: "OneFlagPerTimestamp",
+			signals: slices.Concat(clearPair(t1), []SignalData{
+				signal(t1, "currentLocationLatitude", fencedLat),
+				signal(t1, "currentLocationLongitude", fencedLng),
+			}),
+			want: slices.Concat(clearPair(t1), []SignalData{
+				signal(t1, "currentLocationLatitude", redactedLat),
+				signal(t1, "currentLocationLongitude", redactedLng),
+				flag(t1, true),
+				source(t1),
+				count(1),
+			}),
+			redacted:   1,
+			unredacted: 1,
+		},
+		{
+			name:       "AllClear",
+			signals:    slices.Concat(clearPair(t1), clearPair(t2)),
+			want:       slices.Concat(clearPair(t1), clearPair(t2), []SignalData{flag(t1, false), flag(t2, false), count(0)}),
+			unredacted: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &StatusEventV2[StatusV2Data]{CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+				Vehicle: Vehicle{Signals: tt.signals},
+			}}}
+
+			redacted, unredacted := sanitizeEventV2(event, fence, ParentRedactor{}, locationOptions{signals: DefaultLocationSignals})
+			if redacted != tt.redacted || unredacted != tt.unredacted {
+				t.Errorf("Expected %d redacted and %d unredacted pairs but got %d and %d", tt.redacted, tt.unredacted, redacted, unredacted)
+			}
+			if !reflect.DeepEqual(event.Data.Vehicle.Signals, tt.want) {
+				t.Errorf("Expected signals %+v but got %+v", tt.want, event.Data.Vehicle.Signals)
+			}
+		})
+	}
+}
+
+func TestPrivacyV2Signing(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	signer, err := attestation.NewSigner("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Outputs: []Output{
+			{Stream: "topic.device.status.coarse.v2", Policy: Policy{Level: PolicyCoarse}},
+		},
+		Signer: signer,
+		Logger: &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	coarse := gt.NewQueueTracker(string(fg.Outputs[0].Stream))
+
+	tokenID := "3333"
+
+	gt.SetTableValue(fg.FenceTable, tokenID, &shared.CloudEvent[FenceData]{Data: FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+	}})
+
+	gt.Consume(string(fg.StatusInput), tokenID, &StatusEventV2[StatusV2Data]{
+		CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+			Timestamp: 1713818407248,
+			Device:    map[string]any{"rpiUptimeSecs": 218.0},
+			Vehicle: Vehicle{Signals: []SignalData{
+				{Timestamp: 1713818407248, Name: "latitude", Value: 42.26172693660968},
+				{Timestamp: 1713818407248, Name: "longitude", Value: -83.71029708818693},
+			}},
+		}},
+		Signature: "0xdevice",
+	})
+
+	for _, tracker := range []*tester.QueueTracker{out, coarse} {
+		_, value, ok := tracker.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+
+		event := value.(*StatusEventV2[StatusV2Data])
+		if event.OriginalSignature != "0xdevice" {
+			t.Errorf("Expected the original signature to be kept but got %q", event.OriginalSignature)
+		}
+
+		b, _ := json.Marshal(event)
+		if err := attestation.VerifyEvent(b, signer.Address()); err != nil {
+			t.Errorf("Expected the redacted event to verify: %v", err)
+		}
+		if !bytes.Contains(b, []byte(`"originalsignature":"0xdevice"`)) {
+			t.Errorf("Expected the original signature in a lowercase extension but got %s", b)
+		}
+
+		event.OriginalSignature = "0xforged"
+		b, _ = json.Marshal(event)
+		if err := attestation.VerifyEvent(b, signer.Address()); err == nil {
+			t.Error("Expected the event with a replaced original signature to fail verification")
+		}
+
+		event.OriginalSignature = "0xdevice"
+		event.Subject = "4444"
+		b, _ = json.Marshal(event)
+		if err := attestation.VerifyEvent(b, signer.Address()); err == nil {
+			t.Error("Expected the event replayed under another subject to fail verification")
+		}
+	}
+}
+
+func TestPrivacyV2WithoutSigner(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+
+	gt.Consume(string(fg.StatusInput), "3333", &StatusEventV2[StatusV2Data]{
+		CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{Timestamp: 1713818407248}},
+		Signature:  "0xdevice",
+	})
+
+	_, value, ok := out.Next()
+	if !ok {
+		t.Fatal("No output")
+	}
+	if event := value.(*StatusEventV2[StatusV2Data]); event.Signature != "0xdevice" || event.OriginalSignature != "" {
+		t.Errorf("Expected the signature to be left alone but got %q and original %q", event.Signature, event.OriginalSignature)
+	}
+}
+
+func TestPrivacyV2RedactionSourceReplaced(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Outputs:      []Output{{Stream: "topic.device.status.exact.v2", Policy: Policy{Level: PolicyExact}}},
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	exact := gt.NewQueueTracker(string(fg.Outputs[0].Stream))
+
+	event := trajectoryEvent(1713818400000, 42.261123478313145, -83.68613574673722)
+	event.Data.Vehicle.Signals = append(event.Data.Vehicle.Signals, SignalData{
+		Timestamp: 1713818400000,
+		Name:      redactionSourceSignal,
+		Value:     FenceSourceGlobal,
+	})
+	gt.Consume(string(fg.StatusInput), "3333", event)
+
+	for _, tracker := range []*tester.QueueTracker{out, exact} {
+		_, value, ok := tracker.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+		for _, s := range value.(*StatusEventV2[StatusV2Data]).Data.Vehicle.Signals {
+			if s.Name == redactionSourceSignal {
+				t.Errorf("Expected the upstream redaction source to be removed but got %+v", s)
+			}
+		}
+	}
 }
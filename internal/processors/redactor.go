@@ -0,0 +1,178 @@
+package processors
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/uber/h3-go/v4"
+)
+
+// Redaction strategies that can be named in FenceData and config.Settings.
+const (
+	RedactParent     = "parent"
+	RedactResolution = "resolution"
+	RedactRemove     = "remove"
+	RedactCentroid   = "centroid"
+	RedactNoise      = "noise"
+)
+
+// Redaction selects how points that fall in a zone are redacted.
+type Redaction struct {
+	// Strategy is one of the Redact constants. Empty means RedactParent.
+	Strategy string `json:"strategy,omitempty"`
+	// Resolution is the H3 resolution that RedactResolution snaps points to.
+	Resolution int `json:"resolution,omitempty"`
+	// RadiusMeters is the expected displacement introduced by RedactNoise.
+	RadiusMeters float64 `json:"radiusMeters,omitempty"`
+}
+
+// Redactor decides what is emitted in place of a location that fell in a
+// fence.
+type Redactor interface {
+	// Redact returns the location to emit in place of geo, which was matched
+	// as described by m. If ok is false, the location should be removed from
+	// the output entirely.
+	Redact(geo h3.LatLng, m Match) (out h3.LatLng, ok bool)
+}
+
+// NewRedactor builds the Redactor described by r.
+func NewRedactor(r Redaction) (Redactor, error) {
+	switch r.Strategy {
+	case "", RedactParent:
+		return ParentRedactor{}, nil
+	case RedactResolution:
+		if r.Resolution < 0 || r.Resolution > 15 {
+			return nil, fmt.Errorf("resolution %d out of range", r.Resolution)
+		}
+		return ResolutionRedactor{Resolution: r.Resolution}, nil
+	case RedactRemove:
+		return RemoveRedactor{}, nil
+	case RedactCentroid:
+		return CentroidRedactor{}, nil
+	case RedactNoise:
+		if r.RadiusMeters <= 0 {
+			return nil, fmt.Errorf("noise radius must be positive")
+		}
+		return NoiseRedactor{RadiusMeters: r.RadiusMeters}, nil
+	default:
+		return nil, fmt.Errorf("unknown redaction strategy %q", r.Strategy)
+	}
+}
+
+// redact applies the matched zone's redactor, falling back to def and then to
+// ParentRedactor.
+func redact(geo h3.LatLng, m Match, def Redactor) (h3.LatLng, bool) {
+	r := m.Redactor
+	if r == nil {
+		r = def
+	}
+	if r == nil {
+		r = ParentRedactor{}
+	}
+	if m.maxRes != nil {
+		r = coarseRedactor{Redactor: r, Resolution: *m.maxRes}
+	}
+	return r.Redact(geo, m)
+}
+
+// ParentRedactor replaces a point with the center of the parent of the
+// matched cell.
+type ParentRedactor struct{}
+
+func (ParentRedactor) Redact(_ h3.LatLng, m Match) (h3.LatLng, bool) {
+	// Fence validation keeps resolution 0 cells out of vehicle fences, but
+	// they have no parent to snap to.
+	res := m.Cell.Resolution()
+	if res == 0 {
+		return m.Cell.LatLng(), true
+	}
+	return m.Cell.Parent(res - 1).LatLng(), true
+}
+
+// ResolutionRedactor replaces a point with the center of the cell containing
+// it at a fixed resolution. If a cell was matched, the resolution is lowered
+// to that of its parent if it isn't already coarser, so that a point is never
+// reported more precisely than ParentRedactor would.
+type ResolutionRedactor struct {
+	Resolution int
+}
+
+func (r ResolutionRedactor) Redact(geo h3.LatLng, m Match) (h3.LatLng, bool) {
+	res := r.Resolution
+	if m.Cell != 0 {
+		res = min(res, max(m.Cell.Resolution()-1, 0))
+	}
+	return h3.LatLngToCell(geo, res).LatLng(), true
+}
+
+// coarseRedactor redacts a point with Redactor, but never reports it more
+// precisely than the cell containing it at Resolution would.
+type coarseRedactor struct {
+	Redactor   Redactor
+	Resolution int
+}
+
+func (r coarseRedactor) Redact(geo h3.LatLng, m Match) (h3.LatLng, bool) {
+	switch z := r.Redactor.(type) {
+	case ParentRedactor:
+		if m.Cell.Resolution()-1 <= r.Resolution {
+			return z.Redact(geo, m)
+		}
+		return ResolutionRedactor{Resolution: r.Resolution}.Redact(geo, m)
+	case ResolutionRedactor:
+		return ResolutionRedactor{Resolution: min(z.Resolution, r.Resolution)}.Redact(geo, m)
+	}
+
+	// Other redactors have no resolution to compare against, so their output
+	// is snapped as well.
+	out, ok := r.Redactor.Redact(geo, m)
+	if !ok {
+		return out, false
+	}
+	return h3.LatLngToCell(out, r.Resolution).LatLng(), true
+}
+
+// RemoveRedactor drops the location.
+type RemoveRedactor struct{}
+
+func (RemoveRedactor) Redact(h3.LatLng, Match) (h3.LatLng, bool) {
+	return h3.LatLng{}, false
+}
+
+// CentroidRedactor replaces a point with the centroid of the zone it fell in,
+// so that every point in a zone is reported at the same place.
+type CentroidRedactor struct{}
+
+func (CentroidRedactor) Redact(_ h3.LatLng, m Match) (h3.LatLng, bool) {
+	return m.Centroid, true
+}
+
+// NoiseRedactor displaces a point by planar Laplace noise, which provides
+// geo-indistinguishability. RadiusMeters is the expected displacement.
+type NoiseRedactor struct {
+	RadiusMeters float64
+}
+
+func (r NoiseRedactor) Redact(geo h3.LatLng, _ Match) (h3.LatLng, bool) {
+	// The planar Laplace distance from the true point is Gamma(2, ε)
+	// distributed, i.e. the sum of two Exp(ε) draws, with mean 2/ε.
+	eps := 2 / r.RadiusMeters
+	dist := (rand.ExpFloat64() + rand.ExpFloat64()) / eps
+	bearing := rand.Float64() * 2 * math.Pi
+	return offset(geo, dist, bearing), true
+}
+
+const earthRadiusM = 6371008.8
+
+// offset moves geo dist meters along the given bearing, in radians clockwise
+// from north.
+func offset(geo h3.LatLng, dist, bearing float64) h3.LatLng {
+	lat1, lng1 := geo.Lat*math.Pi/180, geo.Lng*math.Pi/180
+	d := dist / earthRadiusM
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(bearing))
+	lng2 := lng1 + math.Atan2(math.Sin(bearing)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+
+	return h3.NewLatLng(lat2*180/math.Pi, math.Remainder(lng2*180/math.Pi, 360))
+}
@@ -0,0 +1,130 @@
+package processors
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/uber/h3-go/v4"
+)
+
+func TestRedactors(t *testing.T) {
+	geo := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+	m := Match{
+		Cell:     h3.Cell(h3.IndexFromString("872ab259effffff")),
+		Centroid: h3.NewLatLng(42.26, -83.70),
+	}
+
+	tests := []struct {
+		name      string
+		redaction Redaction
+		want      h3.LatLng
+		ok        bool
+	}{
+		{"Default", Redaction{}, h3.NewLatLng(42.25362819577089, -83.68562802176137), true},
+		{"Parent", Redaction{Strategy: RedactParent}, h3.NewLatLng(42.25362819577089, -83.68562802176137), true},
+		{"Resolution", Redaction{Strategy: RedactResolution, Resolution: 5}, h3.LatLngToCell(geo, 5).LatLng(), true},
+		{"ResolutionNotCoarser", Redaction{Strategy: RedactResolution, Resolution: 15}, h3.NewLatLng(42.25362819577089, -83.68562802176137), true},
+		{"Remove", Redaction{Strategy: RedactRemove}, h3.LatLng{}, false},
+		{"Centroid", Redaction{Strategy: RedactCentroid}, m.Centroid, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedactor(tt.redaction)
+			if err != nil {
+				t.Fatalf("Failed to build redactor: %v", err)
+			}
+
+			out, ok := r.Redact(geo, m)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok to be %t but got %t", tt.ok, ok)
+			}
+			if out != tt.want {
+				t.Errorf("Expected %v but got %v", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestNoiseRedactor(t *testing.T) {
+	geo := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+	r := NoiseRedactor{RadiusMeters: 500}
+
+	const n = 10000
+	var total float64
+	for i := 0; i < n; i++ {
+		out, ok := r.Redact(geo, Match{})
+		if !ok {
+			t.Fatal("Expected noise redaction to keep the location")
+		}
+		total += h3.GreatCircleDistanceM(geo, out)
+	}
+
+	if mean := total / n; math.Abs(mean-500) > 25 {
+		t.Errorf("Expected mean displacement near 500 m but got %f", mean)
+	}
+}
+
+func TestNewRedactorInvalid(t *testing.T) {
+	for _, r := range []Redaction{
+		{Strategy: "blur"},
+		{Strategy: RedactResolution, Resolution: 16},
+		{Strategy: RedactNoise},
+	} {
+		if _, err := NewRedactor(r); err == nil {
+			t.Errorf("Expected an error for redaction %+v", r)
+		}
+	}
+}
+
+func TestSanitizeEventZoneRedaction(t *testing.T) {
+	fence, err := NewFence(FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+		Redaction: &Redaction{Strategy: RedactRemove},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error building fence: %v", err)
+	}
+
+	event := &shared.CloudEvent[StatusData]{
+		Time: time.Now(),
+		Data: StatusData{
+			Latitude:  ref(42.26172693660968),
+			Longitude: ref(-83.71029708818693),
+			Overflow:  map[string]any{},
+		},
+	}
+
+	// The zone's own redaction wins over the pipeline default.
+	sanitizeEvent(event, fence, CentroidRedactor{})
+
+	if event.Data.Latitude != nil || event.Data.Longitude != nil {
+		t.Errorf("Expected the location to be removed")
+	}
+	if event.Data.IsRedacted == nil || !*event.Data.IsRedacted {
+		t.Errorf("Expected isRedacted to be true")
+	}
+
+	eventV2 := &StatusEventV2[StatusV2Data]{
+		CloudEvent: shared.CloudEvent[StatusV2Data]{
+			Data: StatusV2Data{
+				Vehicle: Vehicle{
+					Signals: []SignalData{
+						{Timestamp: 1713818407248, Name: "speed", Value: 12.0},
+						{Timestamp: 1713818407248, Name: "latitude", Value: 42.26172693660968},
+						{Timestamp: 1713818407248, Name: "longitude", Value: -83.71029708818693},
+					},
+				},
+			},
+		},
+	}
+
+	sanitizeEventV2(eventV2, fence, nil, locationOptions{signals: DefaultLocationSignals})
+
+	signals := eventV2.Data.Vehicle.Signals
+	if len(signals) != 4 || signals[0].Name != "speed" || signals[1].Name != "IsRedacted" || signals[1].Value != true {
+		t.Errorf("Expected the location signals to be removed and the reading marked redacted, got %+v", signals)
+	}
+}
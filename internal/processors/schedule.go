@@ -0,0 +1,132 @@
+package processors
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schedule describes when a zone is active. All fields are optional; an empty
+// schedule is always active.
+type Schedule struct {
+	// Days are the days of the week on which the schedule is active, numbered
+	// from 0 for Sunday. An empty list means every day.
+	Days []time.Weekday `json:"days,omitempty"`
+	// Start and End bound the daily window, in "15:04" format. An End at or
+	// before Start makes the window wrap past midnight, in which case Days
+	// refers to the day on which the window opens.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	// Timezone is the IANA name of the zone in which the other fields are
+	// interpreted. Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// StartDate and EndDate, in "2006-01-02" format, are the first and last
+	// days on which the schedule is active.
+	StartDate string `json:"startDate,omitempty"`
+	EndDate   string `json:"endDate,omitempty"`
+}
+
+type schedule struct {
+	loc *time.Location
+	// days is a bitmask of active weekdays.
+	days uint8
+	// start and end are minutes after midnight. If window is false, the
+	// schedule is active the whole day.
+	start, end int
+	window     bool
+	// startDate and endDate are midnight in loc, or zero if unbounded.
+	startDate, endDate time.Time
+}
+
+func newSchedule(s Schedule) (*schedule, error) {
+	sc := &schedule{loc: time.UTC}
+
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		sc.loc = loc
+	}
+
+	for _, d := range s.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return nil, fmt.Errorf("invalid day of week %d", d)
+		}
+		sc.days |= 1 << d
+	}
+
+	if s.Start != "" || s.End != "" {
+		var err error
+		if sc.start, err = parseTimeOfDay(s.Start); err != nil {
+			return nil, fmt.Errorf("start: %w", err)
+		}
+		if sc.end, err = parseTimeOfDay(s.End); err != nil {
+			return nil, fmt.Errorf("end: %w", err)
+		}
+		sc.window = true
+	}
+
+	if s.StartDate != "" {
+		d, err := time.ParseInLocation(time.DateOnly, s.StartDate, sc.loc)
+		if err != nil {
+			return nil, fmt.Errorf("startDate: %w", err)
+		}
+		sc.startDate = d
+	}
+
+	if s.EndDate != "" {
+		d, err := time.ParseInLocation(time.DateOnly, s.EndDate, sc.loc)
+		if err != nil {
+			return nil, fmt.Errorf("endDate: %w", err)
+		}
+		sc.endDate = d
+	}
+
+	return sc, nil
+}
+
+// parseTimeOfDay converts "15:04" into minutes after midnight. An empty
+// string is midnight.
+func parseTimeOfDay(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (sc *schedule) activeAt(t time.Time) bool {
+	t = t.In(sc.loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, sc.loc)
+
+	if sc.window {
+		minute := t.Hour()*60 + t.Minute()
+		if sc.start < sc.end {
+			if minute < sc.start || minute >= sc.end {
+				return false
+			}
+		} else if minute < sc.end {
+			// We're in the tail of a window that opened the previous day.
+			day = day.AddDate(0, 0, -1)
+		} else if minute < sc.start {
+			return false
+		}
+	}
+
+	if sc.days != 0 && sc.days&(1<<day.Weekday()) == 0 {
+		return false
+	}
+
+	if !sc.startDate.IsZero() && day.Before(sc.startDate) {
+		return false
+	}
+
+	if !sc.endDate.IsZero() && day.After(sc.endDate) {
+		return false
+	}
+
+	return true
+}
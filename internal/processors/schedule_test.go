@@ -0,0 +1,90 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/h3-go/v4"
+)
+
+func TestScheduleActiveAt(t *testing.T) {
+	detroit, err := time.LoadLocation("America/Detroit")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		time     time.Time
+		active   bool
+	}{
+		{"Empty", Schedule{}, time.Date(2024, 4, 20, 3, 0, 0, 0, time.UTC), true},
+		{"WorkHours", Schedule{Days: weekdays, Start: "08:00", End: "18:00", Timezone: "America/Detroit"}, time.Date(2024, 4, 22, 16, 40, 0, 0, detroit), true},
+		{"BeforeWorkHours", Schedule{Days: weekdays, Start: "08:00", End: "18:00", Timezone: "America/Detroit"}, time.Date(2024, 4, 22, 7, 59, 0, 0, detroit), false},
+		{"EndIsExclusive", Schedule{Days: weekdays, Start: "08:00", End: "18:00", Timezone: "America/Detroit"}, time.Date(2024, 4, 22, 18, 0, 0, 0, detroit), false},
+		{"Weekend", Schedule{Days: weekdays, Start: "08:00", End: "18:00", Timezone: "America/Detroit"}, time.Date(2024, 4, 20, 12, 0, 0, 0, detroit), false},
+		// 2024-04-22T20:40Z is 16:40 in Detroit but 20:40 in UTC.
+		{"TimezoneApplied", Schedule{Start: "08:00", End: "18:00", Timezone: "America/Detroit"}, time.Date(2024, 4, 22, 20, 40, 0, 0, time.UTC), true},
+		{"DefaultsToUTC", Schedule{Start: "08:00", End: "18:00"}, time.Date(2024, 4, 22, 20, 40, 0, 0, time.UTC), false},
+		{"OvernightLate", Schedule{Days: []time.Weekday{time.Friday}, Start: "22:00", End: "06:00"}, time.Date(2024, 4, 26, 23, 0, 0, 0, time.UTC), true},
+		{"OvernightEarly", Schedule{Days: []time.Weekday{time.Friday}, Start: "22:00", End: "06:00"}, time.Date(2024, 4, 27, 5, 0, 0, 0, time.UTC), true},
+		{"OvernightWrongDay", Schedule{Days: []time.Weekday{time.Friday}, Start: "22:00", End: "06:00"}, time.Date(2024, 4, 26, 5, 0, 0, 0, time.UTC), false},
+		{"OvernightGap", Schedule{Start: "22:00", End: "06:00"}, time.Date(2024, 4, 26, 12, 0, 0, 0, time.UTC), false},
+		{"BeforeStartDate", Schedule{StartDate: "2024-05-01"}, time.Date(2024, 4, 30, 23, 59, 0, 0, time.UTC), false},
+		{"OnStartDate", Schedule{StartDate: "2024-05-01"}, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"OnEndDate", Schedule{EndDate: "2024-05-01"}, time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC), true},
+		{"AfterEndDate", Schedule{EndDate: "2024-05-01"}, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := newSchedule(tt.schedule)
+			if err != nil {
+				t.Fatalf("Failed to parse schedule: %v", err)
+			}
+			if active := sc.activeAt(tt.time); active != tt.active {
+				t.Errorf("Expected active to be %t but got %t", tt.active, active)
+			}
+		})
+	}
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	for _, s := range []Schedule{
+		{Timezone: "Mars/Olympus_Mons"},
+		{Days: []time.Weekday{7}},
+		{Start: "8am", End: "18:00"},
+		{StartDate: "May 1"},
+	} {
+		if _, err := newSchedule(s); err == nil {
+			t.Errorf("Expected an error for schedule %+v", s)
+		}
+	}
+}
+
+func TestFenceMatchScheduledZones(t *testing.T) {
+	geo := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+
+	f, err := NewFence(FenceData{
+		Zones: []Zone{{
+			H3Indexes: []string{"872ab259effffff"},
+			Schedules: []Schedule{{Start: "08:00", End: "18:00"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error building fence: %v", err)
+	}
+
+	if _, ok := f.Match(geo, time.Date(2024, 4, 22, 12, 0, 0, 0, time.UTC)); !ok {
+		t.Error("Expected a match while the zone is active")
+	}
+	if _, ok := f.Match(geo, time.Date(2024, 4, 22, 20, 0, 0, 0, time.UTC)); ok {
+		t.Error("Expected no match while the zone is inactive")
+	}
+	if _, ok := f.Match(geo, time.Time{}); !ok {
+		t.Error("Expected a match when the reading time is unknown")
+	}
+}
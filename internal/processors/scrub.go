@@ -0,0 +1,143 @@
+package processors
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Field actions.
+const (
+	// FieldAllow passes the field through unchanged.
+	FieldAllow = "allow"
+	// FieldDrop removes the field.
+	FieldDrop = "drop"
+	// FieldHMAC replaces the field with a keyed hash of its value, so that
+	// equal values can still be correlated without revealing them.
+	FieldHMAC = "hmac"
+)
+
+// FieldPolicy decides what happens to each field of a map.
+type FieldPolicy struct {
+	// Fields maps field names to one of the Field constants.
+	Fields map[string]string
+	// Default applies to fields not in Fields. Defaults to FieldAllow.
+	Default string
+}
+
+// ParseFieldPolicy parses a comma-separated list of "field:action" entries.
+// The field "*" sets the default action. An empty string allows every field.
+func ParseFieldPolicy(s string) (FieldPolicy, error) {
+	var p FieldPolicy
+	if s == "" {
+		return p, nil
+	}
+
+	p.Fields = make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		name, action, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || name == "" {
+			return FieldPolicy{}, fmt.Errorf("field policy %q is not of the form field:action", item)
+		}
+
+		switch action {
+		case FieldAllow, FieldDrop, FieldHMAC:
+		default:
+			return FieldPolicy{}, fmt.Errorf("field policy %q: unknown action %q", item, action)
+		}
+
+		if name == "*" {
+			p.Default = action
+		} else {
+			p.Fields[name] = action
+		}
+	}
+
+	return p, nil
+}
+
+func (p FieldPolicy) action(field string) string {
+	if a, ok := p.Fields[field]; ok {
+		return a
+	}
+	if p.Default != "" {
+		return p.Default
+	}
+	return FieldAllow
+}
+
+func (p FieldPolicy) usesHMAC() bool {
+	if p.Default == FieldHMAC {
+		return true
+	}
+	for _, a := range p.Fields {
+		if a == FieldHMAC {
+			return true
+		}
+	}
+	return false
+}
+
+// Scrubber applies field policies to the device block and the extensions of
+// V2 events.
+type Scrubber struct {
+	device     FieldPolicy
+	extensions FieldPolicy
+	key        []byte
+}
+
+// NewScrubber returns a scrubber for the given policies. key is required if
+// either policy uses FieldHMAC.
+func NewScrubber(device, extensions FieldPolicy, key []byte) (*Scrubber, error) {
+	if len(key) == 0 && (device.usesHMAC() || extensions.usesHMAC()) {
+		return nil, errors.New("field policy uses hmac but no key is set")
+	}
+	return &Scrubber{device: device, extensions: extensions, key: key}, nil
+}
+
+// scrub applies the policies to event. The device and extension maps are
+// replaced rather than modified, so they may be shared with other events.
+func (s *Scrubber) scrub(event *StatusEventV2[StatusV2Data]) {
+	if s == nil {
+		return
+	}
+	event.Data.Device = s.apply(event.Data.Device, s.device)
+	event.Extensions = s.apply(event.Extensions, s.extensions)
+}
+
+func (s *Scrubber) apply(fields map[string]any, p FieldPolicy) map[string]any {
+	if fields == nil {
+		return nil
+	}
+
+	out := make(map[string]any, len(fields))
+	for name, value := range fields {
+		switch p.action(name) {
+		case FieldAllow:
+			out[name] = value
+		case FieldHMAC:
+			out[name] = s.hmac(value)
+		}
+	}
+	return out
+}
+
+// hmac returns the hex-encoded HMAC-SHA256 of value. Strings are hashed as
+// they are and anything else as JSON.
+func (s *Scrubber) hmac(value any) string {
+	var b []byte
+	if str, ok := value.(string); ok {
+		b = []byte(str)
+	} else {
+		// Decoded JSON values always encode.
+		b, _ = json.Marshal(value)
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,204 @@
+package processors
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+)
+
+func TestParseFieldPolicy(t *testing.T) {
+	tests := []struct {
+		input string
+		want  FieldPolicy
+		err   bool
+	}{
+		{"", FieldPolicy{}, false},
+		{
+			"imei:drop, serial:hmac,*:allow",
+			FieldPolicy{Fields: map[string]string{"imei": FieldDrop, "serial": FieldHMAC}, Default: FieldAllow},
+			false,
+		},
+		{"*:drop", FieldPolicy{Fields: map[string]string{}, Default: FieldDrop}, false},
+		{"imei", FieldPolicy{}, true},
+		{":drop", FieldPolicy{}, true},
+		{"imei:encrypt", FieldPolicy{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFieldPolicy(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseFieldPolicy(%q): expected error %t but got %v", tt.input, tt.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFieldPolicy(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestNewScrubberRequiresKey(t *testing.T) {
+	hmacd := FieldPolicy{Fields: map[string]string{"serial": FieldHMAC}}
+
+	if _, err := NewScrubber(hmacd, FieldPolicy{}, nil); err == nil {
+		t.Error("Expected a device policy using hmac to require a key")
+	}
+	if _, err := NewScrubber(FieldPolicy{}, FieldPolicy{Default: FieldHMAC}, nil); err == nil {
+		t.Error("Expected an extension policy using hmac to require a key")
+	}
+	if _, err := NewScrubber(hmacd, FieldPolicy{}, []byte("secret")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func hmacHex(key, value string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestScrubber(t *testing.T) {
+	s, err := NewScrubber(
+		FieldPolicy{Fields: map[string]string{"imei": FieldDrop, "serial": FieldHMAC, "hwVersion": FieldAllow}, Default: FieldDrop},
+		FieldPolicy{Fields: map[string]string{"userDeviceId": FieldHMAC, "year": FieldHMAC, "make": FieldDrop}},
+		[]byte("secret"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := map[string]any{
+		"imei":            "353338970358112",
+		"serial":          "60d4af69-86e8-b790-02d3-c0a9dc4d6c8a",
+		"hwVersion":       "7",
+		"softwareVersion": "v1.0.0",
+	}
+
+	event := &StatusEventV2[StatusV2Data]{
+		Extensions: map[string]any{"userDeviceId": "2fbaXmHpdQiKyAH6o5hHTCYwU0U", "make": "VW", "model": "passat", "year": 2016.0},
+	}
+	event.Data.Device = device
+
+	s.scrub(event)
+
+	wantDevice := map[string]any{
+		"serial":    hmacHex("secret", "60d4af69-86e8-b790-02d3-c0a9dc4d6c8a"),
+		"hwVersion": "7",
+	}
+	if !reflect.DeepEqual(event.Data.Device, wantDevice) {
+		t.Errorf("Expected device %v but got %v", wantDevice, event.Data.Device)
+	}
+
+	wantExtensions := map[string]any{
+		"userDeviceId": hmacHex("secret", "2fbaXmHpdQiKyAH6o5hHTCYwU0U"),
+		"model":        "passat",
+		"year":         hmacHex("secret", "2016"),
+	}
+	if !reflect.DeepEqual(event.Extensions, wantExtensions) {
+		t.Errorf("Expected extensions %v but got %v", wantExtensions, event.Extensions)
+	}
+
+	if _, ok := device["imei"]; !ok {
+		t.Error("Expected the original device map to be left alone")
+	}
+
+	// A nil scrubber does nothing.
+	var none *Scrubber
+	none.scrub(event)
+}
+
+func TestStatusEventV2Extensions(t *testing.T) {
+	b, err := os.ReadFile("testdata/statusV2.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event StatusEventV2[StatusV2Data]
+	if err := json.Unmarshal(b, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{"userDeviceId": "2fbaXmHpdQiKyAH6o5hHTCYwU0U", "make": "VW", "model": "passat", "year": 2016.0}
+	if !reflect.DeepEqual(event.Extensions, want) {
+		t.Errorf("Expected extensions %v but got %v", want, event.Extensions)
+	}
+	if event.VehicleTokenID != 635 || event.Data.Device["imei"] != "353338970358112" {
+		t.Errorf("Expected the CloudEvent to be decoded as before")
+	}
+
+	event.Signature = "0xabc"
+	out, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var again StatusEventV2[StatusV2Data]
+	if err := json.Unmarshal(out, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, event) {
+		t.Errorf("Expected %+v after a round trip but got %+v", event, again)
+	}
+}
+
+func TestPrivacyV2Scrubbing(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	scrubber, _ := NewScrubber(
+		FieldPolicy{Fields: map[string]string{"imei": FieldDrop, "serial": FieldHMAC}},
+		FieldPolicy{Fields: map[string]string{"userDeviceId": FieldDrop}},
+		[]byte("secret"),
+	)
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Outputs: []Output{
+			{Stream: "topic.device.status.exact.v2", Policy: Policy{Level: PolicyExact}},
+		},
+		Scrubber: scrubber,
+		Logger:   &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	exact := gt.NewQueueTracker(string(fg.Outputs[0].Stream))
+
+	b, _ := os.ReadFile("testdata/statusV2.json")
+	gt.Consume(string(fg.StatusInput), "635", json.RawMessage(b))
+
+	for _, tracker := range []*tester.QueueTracker{out, exact} {
+		_, value, ok := tracker.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+
+		event := value.(*StatusEventV2[StatusV2Data])
+		if _, ok := event.Data.Device["imei"]; ok {
+			t.Error("Expected imei to be dropped")
+		}
+		if event.Data.Device["serial"] != hmacHex("secret", "60d4af69-86e8-b790-02d3-c0a9dc4d6c8a") {
+			t.Errorf("Expected serial to be pseudonymized but got %v", event.Data.Device["serial"])
+		}
+		if _, ok := event.Extensions["userDeviceId"]; ok {
+			t.Error("Expected userDeviceId to be dropped")
+		}
+		if event.Extensions["make"] != "VW" {
+			t.Errorf("Expected other extensions to be kept but got %v", event.Extensions)
+		}
+	}
+}
@@ -1,17 +1,24 @@
 package processors
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"slices"
 
 	"github.com/DIMO-Network/shared"
 )
 
 type StatusData struct {
-	Latitude   *float64       `json:"latitude"`
-	Longitude  *float64       `json:"longitude"`
-	IsRedacted *bool          `json:"isRedacted"`
-	Overflow   map[string]any `json:"-"`
+	Latitude   *float64 `json:"latitude"`
+	Longitude  *float64 `json:"longitude"`
+	IsRedacted *bool    `json:"isRedacted"`
+	// RedactionSource is the FenceSource that caused the redaction, if any.
+	RedactionSource string `json:"redactionSource,omitempty"`
+	// FenceVersion is the FenceData.Version of the vehicle's fence that the
+	// location was checked against, if it has one.
+	FenceVersion int64          `json:"fenceVersion,omitempty"`
+	Overflow     map[string]any `json:"-"`
 }
 
 func (d *StatusData) MarshalJSON() ([]byte, error) {
@@ -27,6 +34,14 @@ func (d *StatusData) MarshalJSON() ([]byte, error) {
 		d.Overflow["isRedacted"] = *d.IsRedacted
 	}
 
+	if d.RedactionSource != "" {
+		d.Overflow["redactionSource"] = d.RedactionSource
+	}
+
+	if d.FenceVersion != 0 {
+		d.Overflow["fenceVersion"] = d.FenceVersion
+	}
+
 	return json.Marshal(d.Overflow)
 }
 
@@ -68,12 +83,132 @@ func (d *StatusData) UnmarshalJSON(data []byte) error {
 		delete(d.Overflow, "isRedacted")
 	}
 
+	if rs, ok := d.Overflow["redactionSource"]; ok {
+		if rs != nil {
+			rsS, ok := rs.(string)
+			if !ok {
+				return fmt.Errorf("redactionSource field was not a JSON string")
+			}
+			d.RedactionSource = rsS
+		}
+		delete(d.Overflow, "redactionSource")
+	}
+
+	if fv, ok := d.Overflow["fenceVersion"]; ok {
+		if fv != nil {
+			fvF, ok := fv.(float64)
+			if !ok {
+				return fmt.Errorf("fenceVersion field was not a JSON number")
+			}
+			d.FenceVersion = int64(fvF)
+		}
+		delete(d.Overflow, "fenceVersion")
+	}
+
 	return nil
 }
 
 type StatusEventV2[A any] struct {
 	shared.CloudEvent[A]
 	Signature string `json:"signature"`
+	// OriginalSignature is the signature of the event as it was received,
+	// before redaction.
+	OriginalSignature string `json:"originalsignature,omitempty"`
+	// Extensions holds any other top-level attributes of the event.
+	Extensions map[string]any `json:"-"`
+}
+
+// v2Attributes are the top-level attributes that StatusEventV2 decodes
+// itself, as opposed to keeping them in Extensions.
+var v2Attributes = []string{
+	"id", "source", "specversion", "subject", "time", "type",
+	"datacontenttype", "dataschema", "vehicleTokenId", "data",
+	"signature", "originalsignature",
+}
+
+func (e StatusEventV2[A]) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(e.CloudEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Append the remaining attributes to the CloudEvent object.
+	buf := bytes.NewBuffer(b[:len(b)-1])
+	writeAttr := func(name string, value any) error {
+		v, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("attribute %s: %w", name, err)
+		}
+		k, _ := json.Marshal(name)
+		buf.WriteByte(',')
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+		return nil
+	}
+
+	if err := writeAttr("signature", e.Signature); err != nil {
+		return nil, err
+	}
+	if e.OriginalSignature != "" {
+		if err := writeAttr("originalsignature", e.OriginalSignature); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(e.Extensions))
+	for name := range e.Extensions {
+		if !slices.Contains(v2Attributes, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := writeAttr(name, e.Extensions[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (e *StatusEventV2[A]) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &e.CloudEvent); err != nil {
+		return err
+	}
+
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return err
+	}
+
+	e.Signature, e.OriginalSignature, e.Extensions = "", "", nil
+	for name, raw := range attrs {
+		var err error
+		switch name {
+		case "signature":
+			err = json.Unmarshal(raw, &e.Signature)
+		case "originalsignature":
+			err = json.Unmarshal(raw, &e.OriginalSignature)
+		default:
+			if slices.Contains(v2Attributes, name) {
+				continue
+			}
+			var value any
+			err = json.Unmarshal(raw, &value)
+			if e.Extensions == nil {
+				e.Extensions = make(map[string]any)
+			}
+			e.Extensions[name] = value
+		}
+		if err != nil {
+			return fmt.Errorf("attribute %s: %w", name, err)
+		}
+	}
+
+	return nil
 }
 
 type Vehicle struct {
@@ -0,0 +1,316 @@
+package processors
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+// PipelineSuggestions labels the metrics of the suggestions processor.
+const PipelineSuggestions = "suggestions"
+
+const suggestionsEventType = "zone.dimo.privacy.suggestions"
+
+// Defaults for the suggestions processor.
+const (
+	// Cells at resolution 9 are about 0.1 km² in area, about a city block.
+	defaultSuggestionResolution = 9
+	defaultSuggestionMinDwell   = 10 * time.Minute
+	// Parked vehicles report rarely, but longer gaps are more likely to hide
+	// a trip.
+	defaultSuggestionMaxGap = time.Hour
+	defaultSuggestionTop    = 3
+	// maxDwellCells bounds the cells tracked for each vehicle. The cell with
+	// the least dwell time is forgotten to make room for a new one.
+	maxDwellCells = 50
+)
+
+// Suggestions keeps dwell statistics of every vehicle over H3 cells and
+// suggests the places it stops at most as privacy zones. It consumes the V2
+// status stream.
+type Suggestions struct {
+	Group       goka.Group
+	StatusInput goka.Stream
+	// FenceTable, if set, is joined so that places already covered by the
+	// vehicle's fence are not suggested.
+	FenceTable        goka.Table
+	SuggestionsOutput goka.Stream
+	// DeadLetterOutput, if set, receives messages that can't be decoded.
+	// Otherwise they are only logged. The V2 processor usually dead-letters
+	// the same messages already.
+	DeadLetterOutput goka.Stream
+	// Resolution is the H3 resolution of the cells. Defaults to
+	// defaultSuggestionResolution.
+	Resolution int
+	// MinDwell is how long a vehicle must stay in a cell for the stay to be
+	// counted. Defaults to defaultSuggestionMinDwell.
+	MinDwell time.Duration
+	// MaxGap bounds the time counted between two locations in the same
+	// cell, so that a vehicle that stops reporting isn't credited with the
+	// whole gap. Defaults to defaultSuggestionMaxGap.
+	MaxGap time.Duration
+	// Location is the time zone in which stays are counted by hour of day.
+	// Vehicles are assumed to be in it. Defaults to UTC.
+	Location *time.Location
+	// Top is the number of cells suggested. Defaults to defaultSuggestionTop.
+	Top int
+	// LocationSignals lists the signals that carry locations. Defaults to
+	// DefaultLocationSignals.
+	LocationSignals []LocationSignals
+
+	Logger *zerolog.Logger
+}
+
+// ZoneSuggestions is the data of an event on the suggestions topic. It
+// replaces any earlier suggestions for the vehicle.
+type ZoneSuggestions struct {
+	Suggestions []ZoneSuggestion `json:"suggestions"`
+	// TimeZone is the IANA name of the time zone of ZoneSuggestion.Hours.
+	TimeZone string `json:"timeZone"`
+}
+
+// ZoneSuggestion is a place where a vehicle often stops.
+type ZoneSuggestion struct {
+	Cell string `json:"cell"`
+	// Stays is the number of times the vehicle stayed in the cell, and
+	// DwellSeconds the total time it spent there.
+	Stays        int   `json:"stays"`
+	DwellSeconds int64 `json:"dwellSeconds"`
+	// Hours counts the stays by the hour at which they began, in
+	// ZoneSuggestions.TimeZone.
+	Hours [24]int `json:"hours"`
+	// Zone can be added to FenceData.Zones as it is.
+	Zone Zone `json:"zone"`
+}
+
+// dwellState is the group table value for a vehicle.
+type dwellState struct {
+	// Cell is where the vehicle is now, since Entered. LastSeen is the time
+	// of the newest location. Dwell is the time spent in Cell so far, which
+	// is that between its locations, each gap counted up to MaxGap. All are
+	// in unix millis.
+	Cell     string `json:"cell,omitempty"`
+	Entered  int64  `json:"entered,omitempty"`
+	LastSeen int64  `json:"lastSeen,omitempty"`
+	Dwell    int64  `json:"dwell,omitempty"`
+	// Cells holds the statistics of every cell the vehicle stayed in.
+	Cells map[string]*dwellCell `json:"cells,omitempty"`
+}
+
+// dwellOptions are the settings of Suggestions that observe needs.
+type dwellOptions struct {
+	minDwell, maxGap time.Duration
+	location         *time.Location
+}
+
+type dwellCell struct {
+	Stays        int     `json:"stays"`
+	DwellSeconds int64   `json:"dwellSeconds"`
+	Hours        [24]int `json:"hours"`
+}
+
+func (g *Suggestions) Define() *goka.GroupGraph {
+	edges := []goka.Edge{
+		goka.Input(g.StatusInput, inputCodec[StatusEventV2[StatusV2Data]]{PipelineSuggestions}, g.processStatusEvent),
+		goka.Output(g.SuggestionsOutput, new(shared.JSONCodec[shared.CloudEvent[ZoneSuggestions]])),
+		goka.Persist(new(shared.JSONCodec[dwellState])),
+	}
+	if g.DeadLetterOutput != "" {
+		edges = append(edges, goka.Output(g.DeadLetterOutput, new(shared.JSONCodec[shared.CloudEvent[DeadLetter]])))
+	}
+
+	if g.FenceTable != "" {
+		edges = append(edges, goka.Join(g.FenceTable, new(shared.JSONCodec[shared.CloudEvent[FenceData]])))
+	}
+
+	return goka.DefineGroup(g.Group, edges...)
+}
+
+func (g *Suggestions) processStatusEvent(ctx goka.Context, msg interface{}) {
+	defer observeDuration(PipelineSuggestions, time.Now())
+	messagesConsumed.WithLabelValues(PipelineSuggestions).Inc()
+
+	in := msg.(*inputMessage[StatusEventV2[StatusV2Data]])
+	if in.Err != nil {
+		deadLetter(ctx, PipelineSuggestions, g.DeadLetterOutput, g.Logger, ReasonDecodeError, in.Raw, in.Err)
+		return
+	}
+	event := in.Value
+
+	state, _ := ctx.Value().(*dwellState)
+	if state == nil {
+		state = new(dwellState)
+	}
+
+	signals := g.LocationSignals
+	if len(signals) == 0 {
+		signals = DefaultLocationSignals
+	}
+
+	pairs, _ := pairLocations(event.Data.Vehicle.Signals, signals, 0)
+	slices.SortStableFunc(pairs, func(a, b locationPair) int {
+		return cmp.Compare(a.timestamp, b.timestamp)
+	})
+
+	opts := g.dwellOptions()
+	changed, stayed := false, false
+	for _, p := range pairs {
+		lat, latOK := coordinate(event.Data.Vehicle.Signals[p.lat].Value)
+		lng, lngOK := coordinate(event.Data.Vehicle.Signals[p.lng].Value)
+		if !latOK || !lngOK || validateLatitude(lat) != nil || validateLongitude(lng) != nil {
+			continue
+		}
+		cell := h3.LatLngToCell(h3.NewLatLng(lat, lng), g.resolution())
+		c, st := state.observe(cell.String(), signalTime(event, p.timestamp).UnixMilli(), opts)
+		changed, stayed = changed || c, stayed || st
+	}
+
+	// Most events carry no location, or repeat the last one.
+	if changed {
+		ctx.SetValue(state)
+	}
+
+	if !stayed {
+		return
+	}
+
+	fence, err := g.fence(ctx)
+	if err != nil {
+		g.Logger.Err(err).Str("key", ctx.Key()).Msg("Privacy fence has invalid shapes, ignoring them.")
+	}
+
+	emit(ctx, PipelineSuggestions, g.SuggestionsOutput, &shared.CloudEvent[ZoneSuggestions]{
+		ID:          fmt.Sprintf("%s-%d-%d", ctx.Topic(), ctx.Partition(), ctx.Offset()),
+		Source:      "privacy-processor",
+		SpecVersion: "1.0",
+		Subject:     ctx.Key(),
+		Time:        time.Now(),
+		Type:        suggestionsEventType,
+		Data:        ZoneSuggestions{Suggestions: state.suggest(g.top(), fence), TimeZone: opts.location.String()},
+	})
+}
+
+func (g *Suggestions) fence(ctx goka.Context) (*Fence, error) {
+	if g.FenceTable == "" {
+		return nil, nil
+	}
+	return getFence(ctx, g.FenceTable, nil)
+}
+
+func (g *Suggestions) resolution() int {
+	if g.Resolution == 0 {
+		return defaultSuggestionResolution
+	}
+	return g.Resolution
+}
+
+func (g *Suggestions) dwellOptions() dwellOptions {
+	opts := dwellOptions{minDwell: g.MinDwell, maxGap: g.MaxGap, location: g.Location}
+	if opts.minDwell == 0 {
+		opts.minDwell = defaultSuggestionMinDwell
+	}
+	if opts.maxGap == 0 {
+		opts.maxGap = defaultSuggestionMaxGap
+	}
+	if opts.location == nil {
+		opts.location = time.UTC
+	}
+	return opts
+}
+
+func (g *Suggestions) top() int {
+	if g.Top <= 0 {
+		return defaultSuggestionTop
+	}
+	return g.Top
+}
+
+// observe moves the vehicle to cell at the given time, and reports whether
+// that changed the state and whether it ended a stay of at least minDwell. A
+// stay ends at the last location in its cell, since the vehicle may have left
+// any time after. Locations older than the newest one are ignored.
+func (s *dwellState) observe(cell string, timestamp int64, opts dwellOptions) (changed, stayed bool) {
+	if timestamp < s.LastSeen || cell == s.Cell && timestamp == s.LastSeen {
+		return false, false
+	}
+
+	if cell == s.Cell {
+		s.Dwell += min(timestamp-s.LastSeen, opts.maxGap.Milliseconds())
+		s.LastSeen = timestamp
+		return true, false
+	}
+
+	if s.Cell != "" && s.Dwell >= opts.minDwell.Milliseconds() {
+		s.record(s.Cell, s.Entered, s.Dwell, opts.location)
+		stayed = true
+	}
+
+	s.Cell, s.Entered, s.LastSeen, s.Dwell = cell, timestamp, timestamp, 0
+	return true, stayed
+}
+
+// record adds a stay in cell that began at from and lasted dwell, both in
+// unix millis. It is counted by its hour in loc.
+func (s *dwellState) record(cell string, from, dwell int64, loc *time.Location) {
+	if s.Cells == nil {
+		s.Cells = make(map[string]*dwellCell)
+	}
+
+	c, ok := s.Cells[cell]
+	if !ok {
+		if len(s.Cells) >= maxDwellCells {
+			delete(s.Cells, s.ranked()[len(s.Cells)-1])
+		}
+		c = new(dwellCell)
+		s.Cells[cell] = c
+	}
+
+	c.Stays++
+	c.DwellSeconds += dwell / 1000
+	c.Hours[time.UnixMilli(from).In(loc).Hour()]++
+}
+
+// ranked returns the cells by decreasing dwell time.
+func (s *dwellState) ranked() []string {
+	cells := make([]string, 0, len(s.Cells))
+	for cell := range s.Cells {
+		cells = append(cells, cell)
+	}
+	slices.SortFunc(cells, func(a, b string) int {
+		if c := cmp.Compare(s.Cells[b].DwellSeconds, s.Cells[a].DwellSeconds); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return cells
+}
+
+// suggest returns up to n of the cells with the most dwell time, leaving out
+// those already in fence.
+func (s *dwellState) suggest(n int, fence *Fence) []ZoneSuggestion {
+	out := []ZoneSuggestion{}
+	for _, cell := range s.ranked() {
+		if len(out) == n {
+			break
+		}
+		if _, ok := fence.Match(h3.Cell(h3.IndexFromString(cell)).LatLng(), time.Time{}); ok {
+			continue
+		}
+
+		c := s.Cells[cell]
+		out = append(out, ZoneSuggestion{
+			Cell:         cell,
+			Stays:        c.Stays,
+			DwellSeconds: c.DwellSeconds,
+			Hours:        c.Hours,
+			Zone:         Zone{H3Indexes: []string{cell}},
+		})
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package processors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+)
+
+func TestSuggestions(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	sg := Suggestions{
+		Group:             "privacy-suggestions",
+		StatusInput:       "topic.device.status.v2",
+		FenceTable:        "table.device.privacyfence.v2",
+		SuggestionsOutput: "topic.privacy.suggestions",
+		Resolution:        7,
+		Logger:            &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, sg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(sg.SuggestionsOutput))
+
+	fencedID, unfencedID := "3333", "4444"
+
+	gt.SetTableValue(sg.FenceTable, fencedID, &shared.CloudEvent[FenceData]{Data: FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+	}})
+
+	// 2024-04-22 20:40 UTC.
+	const t0 = 1713818400000
+	for _, id := range []string{fencedID, unfencedID} {
+		gt.Consume(string(sg.StatusInput), id, trajectoryEvent(t0, 42.26172693660968, -83.71029708818693))
+		gt.Consume(string(sg.StatusInput), id, trajectoryEvent(t0+int64(30*time.Minute/time.Millisecond), 42.26172693660968, -83.71029708818693))
+		// A short stop is not suggested.
+		gt.Consume(string(sg.StatusInput), id, trajectoryEvent(t0+int64(31*time.Minute/time.Millisecond), 42.261123478313145, -83.68613574673722))
+		gt.Consume(string(sg.StatusInput), id, trajectoryEvent(t0+int64(32*time.Minute/time.Millisecond), 42.26172693660968, -83.71029708818693))
+	}
+
+	key, value, ok := out.Next()
+	if !ok || key != fencedID {
+		t.Fatalf("Expected suggestions for %s", fencedID)
+	}
+	if s := value.(*shared.CloudEvent[ZoneSuggestions]).Data.Suggestions; len(s) != 0 {
+		t.Errorf("Expected a place covered by the fence not to be suggested but got %+v", s)
+	}
+
+	key, value, ok = out.Next()
+	if !ok || key != unfencedID {
+		t.Fatalf("Expected suggestions for %s", unfencedID)
+	}
+	event := value.(*shared.CloudEvent[ZoneSuggestions])
+	if event.Type != suggestionsEventType || event.Subject != unfencedID {
+		t.Errorf("Unexpected event attributes %+v", event)
+	}
+
+	s := event.Data.Suggestions
+	if len(s) != 1 {
+		t.Fatalf("Expected 1 suggestion but got %+v", s)
+	}
+	if s[0].Cell != "872ab259effffff" || s[0].Stays != 1 || s[0].DwellSeconds != 30*60 || s[0].Hours[20] != 1 {
+		t.Errorf("Unexpected suggestion %+v", s[0])
+	}
+	if event.Data.TimeZone != "UTC" {
+		t.Errorf("Expected hours in UTC but got %s", event.Data.TimeZone)
+	}
+	if len(s[0].Zone.H3Indexes) != 1 || s[0].Zone.H3Indexes[0] != s[0].Cell {
+		t.Errorf("Expected the suggestion to carry a zone of its cell but got %+v", s[0].Zone)
+	}
+
+	if _, _, ok := out.Next(); ok {
+		t.Error("Expected no more suggestions")
+	}
+}
+
+func TestDwellStateEviction(t *testing.T) {
+	var s dwellState
+	for i := range maxDwellCells + 1 {
+		s.record(string(rune('A'+i)), 0, int64(i+1)*1000, time.UTC)
+	}
+
+	if len(s.Cells) != maxDwellCells {
+		t.Fatalf("Expected %d cells but got %d", maxDwellCells, len(s.Cells))
+	}
+	if _, ok := s.Cells["A"]; ok {
+		t.Error("Expected the cell with the least dwell time to be forgotten")
+	}
+}
+
+func TestDwellStateObserve(t *testing.T) {
+	detroit, err := time.LoadLocation("America/Detroit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := dwellOptions{minDwell: 10 * time.Minute, maxGap: time.Hour, location: detroit}
+
+	// 2024-04-22 20:40 UTC, 16:40 in Detroit.
+	const t0 = 1713818400000
+	minute := time.Minute.Milliseconds()
+
+	var s dwellState
+	for _, ts := range []int64{t0, t0 + 10*minute, t0 + 300*minute} {
+		if changed, stayed := s.observe("A", ts, opts); !changed || stayed {
+			t.Fatal("Expected no stay to end in the same cell")
+		}
+	}
+	// The vehicle left some time in the hour before this.
+	if _, stayed := s.observe("B", t0+360*minute, opts); !stayed {
+		t.Fatal("Expected the stay in A to end")
+	}
+	if changed, _ := s.observe("B", t0+360*minute, opts); changed {
+		t.Error("Expected a repeated location not to change the state")
+	}
+
+	c := s.Cells["A"]
+	if c == nil || c.Stays != 1 {
+		t.Fatalf("Expected a stay in A but got %+v", s.Cells)
+	}
+	// 10 minutes, and the 290 minute gap capped at an hour.
+	if c.DwellSeconds != 70*60 {
+		t.Errorf("Expected 70 minutes of dwell but got %d seconds", c.DwellSeconds)
+	}
+	if c.Hours[16] != 1 {
+		t.Errorf("Expected the stay to be counted at 16:00 local time but got %v", c.Hours)
+	}
+}
+
+func TestSuggestionsLeaveStateWithoutLocations(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	sg := Suggestions{
+		Group:             "privacy-suggestions",
+		StatusInput:       "topic.device.status.v2",
+		SuggestionsOutput: "topic.privacy.suggestions",
+		Logger:            &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, sg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	changelog := gt.NewQueueTracker(string(goka.GroupTable(sg.Group)))
+
+	gt.Consume(string(sg.StatusInput), "3333", &StatusEventV2[StatusV2Data]{
+		CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{Vehicle: Vehicle{Signals: []SignalData{
+			{Timestamp: 1713818400000, Name: "speed", Value: 20.0},
+		}}}},
+	})
+
+	if _, value, ok := changelog.Next(); ok {
+		t.Errorf("Expected the unchanged state not to be written but got %+v", value)
+	}
+}
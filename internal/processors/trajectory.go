@@ -0,0 +1,271 @@
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/uber/h3-go/v4"
+)
+
+// defaultTrajectoryMaxEvents is used when Trajectory.MaxEvents is unset.
+const defaultTrajectoryMaxEvents = 100
+
+// trajectoryVisitor is the name of the visitor that releases held events.
+const trajectoryVisitor = "flush-trajectories"
+
+// Reasons for releasing held events, for metrics.
+const (
+	releaseNewer    = "newer"
+	releaseOverflow = "overflow"
+	releaseFlush    = "flush"
+)
+
+// Trajectory configures the redaction of locations just before a vehicle
+// enters a zone and just after it leaves one. Snapping only the locations
+// inside a zone leaves the path up to its edge in the clear, which is enough
+// to find the zone.
+//
+// To redact an approach, events are held back for Delay in the processor's
+// group table. A held event is released once the vehicle has reported data
+// Delay newer than it, or Delay after it was received if the vehicle has gone
+// quiet. See PrivacyV2.FlushTrajectories.
+type Trajectory struct {
+	// Window is how long before entering and after leaving a zone locations
+	// are redacted.
+	Window time.Duration
+	// Meters is how close locations must be to a recent location in a zone to
+	// be redacted, however far apart in time they are. Zone locations are
+	// remembered for Window plus Delay. Zero disables the check.
+	Meters float64
+	// Delay is how long events are held back. It is raised to Window if it is
+	// shorter, so that the whole approach to a zone is redacted. It must be
+	// set if only Meters is.
+	Delay time.Duration
+	// MaxEvents bounds the events held back and the zone locations
+	// remembered for each vehicle. The oldest events are released early when
+	// there are more. Defaults to defaultTrajectoryMaxEvents.
+	MaxEvents int
+	// TripEndpoints, if set, also coarsens locations around trip endpoints
+	// for vehicles that ask for it.
+	TripEndpoints *TripEndpoints
+}
+
+func (t *Trajectory) delay() time.Duration {
+	return max(t.Delay, t.window())
+}
+
+// Check reports settings under which events would not be held back long
+// enough to be redacted.
+func (t *Trajectory) Check() error {
+	if t.delay() == 0 {
+		return errors.New("a delay or a window is needed to redact approaches")
+	}
+	if t.TripEndpoints != nil && t.delay().Milliseconds() < t.TripEndpoints.parkedAfter() {
+		return fmt.Errorf("a delay of %s is shorter than the %s it takes for a trip to end", t.delay(), time.Duration(t.TripEndpoints.parkedAfter())*time.Millisecond)
+	}
+	return nil
+}
+
+// window is the longest time away from an anchor that a location is redacted.
+func (t *Trajectory) window() time.Duration {
+	if t.TripEndpoints != nil {
+		return max(t.Window, t.TripEndpoints.Window)
+	}
+	return t.Window
+}
+
+func (t *Trajectory) maxEvents() int {
+	if t.MaxEvents <= 0 {
+		return defaultTrajectoryMaxEvents
+	}
+	return t.MaxEvents
+}
+
+// trajectoryState is the group table value for a vehicle.
+type trajectoryState struct {
+	// Newest is the time of the newest location seen, in unix millis.
+	Newest int64 `json:"newest"`
+	// Held are the events not yet released, in the order received.
+	Held []heldEvent `json:"held,omitempty"`
+	// Anchors are recent locations that were in a zone, and trip endpoints.
+	Anchors []trajectoryAnchor `json:"anchors,omitempty"`
+	// Ignition and Last are the last known ignition state and location, for
+	// finding trip endpoints.
+	Ignition *bool            `json:"ignition,omitempty"`
+	Last     *trajectoryPoint `json:"last,omitempty"`
+	// Moving is whether the vehicle is moving, for vehicles that don't
+	// report their ignition. StoppedAt is when it last stopped while moving,
+	// and MotionAt the time of its newest speed. Both are in unix millis.
+	Moving    *bool `json:"moving,omitempty"`
+	StoppedAt int64 `json:"stoppedAt,omitempty"`
+	MotionAt  int64 `json:"motionAt,omitempty"`
+}
+
+type heldEvent struct {
+	// Received is when the event was consumed, and Latest the time of its
+	// newest location. Both are in unix millis.
+	Received int64                        `json:"received"`
+	Latest   int64                        `json:"latest"`
+	Event    *StatusEventV2[StatusV2Data] `json:"event"`
+}
+
+type trajectoryPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// trajectoryAnchor is a location in a zone and what it was redacted to.
+// Locations near it are redacted to the same place. Trip endpoints are
+// anchors too, but locations near them are coarsened instead.
+type trajectoryAnchor struct {
+	trajectoryPoint
+	OutLatitude  float64 `json:"outLatitude"`
+	OutLongitude float64 `json:"outLongitude"`
+	// Removed is set if the location was removed rather than redacted.
+	Removed  bool `json:"removed,omitempty"`
+	Endpoint bool `json:"endpoint,omitempty"`
+	// Source is the FenceSource of the anchor.
+	Source string `json:"source,omitempty"`
+}
+
+// hold remembers the zone locations and trip endpoints of event and adds it
+// to the held events.
+func (s *trajectoryState) hold(event *StatusEventV2[StatusV2Data], t *Trajectory, fence *Fence, redactor Redactor, opts locationOptions, received time.Time) {
+	h := heldEvent{Received: received.UnixMilli(), Event: event}
+
+	pairs, _ := pairLocations(event.Data.Vehicle.Signals, opts.signals, opts.tolerance)
+	for _, p := range pairs {
+		lat, latOK := coordinate(event.Data.Vehicle.Signals[p.lat].Value)
+		lng, lngOK := coordinate(event.Data.Vehicle.Signals[p.lng].Value)
+		if !latOK || !lngOK {
+			continue
+		}
+
+		at := signalTime(event, p.timestamp)
+		h.Latest = max(h.Latest, at.UnixMilli())
+
+		geo := h3.NewLatLng(lat, lng)
+		m, ok := fence.Match(geo, at)
+		if !ok {
+			continue
+		}
+
+		a := trajectoryAnchor{
+			trajectoryPoint: trajectoryPoint{Timestamp: at.UnixMilli(), Latitude: lat, Longitude: lng},
+			Source:          m.Source,
+		}
+		if out, ok := redact(geo, m, redactor); ok {
+			a.OutLatitude, a.OutLongitude = out.Lat, out.Lng
+		} else {
+			a.Removed = true
+		}
+		s.Anchors = append(s.Anchors, a)
+	}
+
+	if t.TripEndpoints != nil && fence.obfuscatesTripEndpoints() {
+		s.observeEndpoints(event, t.TripEndpoints, opts)
+	} else {
+		s.Ignition, s.Last = nil, nil
+		s.Moving, s.StoppedAt, s.MotionAt = nil, 0, 0
+	}
+
+	if h.Latest == 0 {
+		h.Latest = h.Received
+	}
+	s.Newest = max(s.Newest, h.Latest)
+	s.Held = append(s.Held, h)
+}
+
+// due removes and returns the held events that the newest data has moved
+// Delay past, and the oldest events beyond MaxEvents.
+func (s *trajectoryState) due(pipeline string, t *Trajectory) []*StatusEventV2[StatusV2Data] {
+	var out []*StatusEventV2[StatusV2Data]
+
+	if n := len(s.Held) - t.maxEvents(); n > 0 {
+		for _, h := range s.Held[:n] {
+			out = append(out, h.Event)
+		}
+		s.Held = s.Held[n:]
+		trajectoryReleases.WithLabelValues(pipeline, releaseOverflow).Add(float64(n))
+	}
+
+	horizon := s.Newest - t.delay().Milliseconds()
+	return append(out, s.release(pipeline, releaseNewer, func(h heldEvent) bool {
+		return h.Latest <= horizon
+	})...)
+}
+
+// expired removes and returns the held events that were received at least
+// Delay before now.
+func (s *trajectoryState) expired(pipeline string, t *Trajectory, now time.Time) []*StatusEventV2[StatusV2Data] {
+	horizon := now.Add(-t.delay()).UnixMilli()
+	return s.release(pipeline, releaseFlush, func(h heldEvent) bool {
+		return h.Received <= horizon
+	})
+}
+
+func (s *trajectoryState) release(pipeline, reason string, ok func(heldEvent) bool) []*StatusEventV2[StatusV2Data] {
+	var out []*StatusEventV2[StatusV2Data]
+	s.Held = slices.DeleteFunc(s.Held, func(h heldEvent) bool {
+		if ok(h) {
+			out = append(out, h.Event)
+			return true
+		}
+		return false
+	})
+	trajectoryReleases.WithLabelValues(pipeline, reason).Add(float64(len(out)))
+	return out
+}
+
+// nearZone returns the anchor nearest in time to a location that is within
+// Window or Meters of one, or of a trip endpoint.
+func (s *trajectoryState) nearZone(t *Trajectory) func(h3.LatLng, time.Time) (trajectoryAnchor, bool) {
+	return func(geo h3.LatLng, at time.Time) (trajectoryAnchor, bool) {
+		var best trajectoryAnchor
+		found := false
+		for _, a := range s.Anchors {
+			window, meters := t.Window, t.Meters
+			if a.Endpoint {
+				if t.TripEndpoints == nil {
+					continue
+				}
+				window, meters = t.TripEndpoints.Window, t.TripEndpoints.Meters
+			}
+
+			d := timeDistance(a.Timestamp, at.UnixMilli())
+			if d > window.Milliseconds() && (meters <= 0 || h3.GreatCircleDistanceM(geo, h3.NewLatLng(a.Latitude, a.Longitude)) > meters) {
+				continue
+			}
+			if !found || d < timeDistance(best.Timestamp, at.UnixMilli()) {
+				best = a
+				found = true
+			}
+		}
+		if found && best.Endpoint {
+			best = t.TripEndpoints.coarsen(best, geo)
+		}
+		return best, found
+	}
+}
+
+// prune forgets anchors that no held or future event can be near in time,
+// given the time of the newest data in unix millis, and the oldest ones
+// beyond MaxEvents. It reports whether any were forgotten.
+func (s *trajectoryState) prune(t *Trajectory, newest int64) bool {
+	before := len(s.Anchors)
+	horizon := newest - (t.window() + t.delay()).Milliseconds()
+	s.Anchors = slices.DeleteFunc(s.Anchors, func(a trajectoryAnchor) bool {
+		return a.Timestamp < horizon
+	})
+	if n := len(s.Anchors) - t.maxEvents(); n > 0 {
+		s.Anchors = s.Anchors[n:]
+	}
+	return len(s.Anchors) != before
+}
+
+func (s *trajectoryState) empty() bool {
+	return len(s.Held) == 0 && len(s.Anchors) == 0 && s.Ignition == nil && s.Moving == nil
+}
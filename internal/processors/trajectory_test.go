@@ -0,0 +1,200 @@
+package processors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+func trajectoryEvent(timestamp int64, lat, lng float64) *StatusEventV2[StatusV2Data] {
+	return &StatusEventV2[StatusV2Data]{
+		CloudEvent: shared.CloudEvent[StatusV2Data]{Data: StatusV2Data{
+			Timestamp: timestamp,
+			Vehicle: Vehicle{Signals: []SignalData{
+				{Timestamp: timestamp, Name: "latitude", Value: lat},
+				{Timestamp: timestamp, Name: "longitude", Value: lng},
+			}},
+		}},
+	}
+}
+
+func TestPrivacyV2Trajectory(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Trajectory:   &Trajectory{Window: time.Minute, Delay: 2 * time.Minute},
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+
+	tokenID := "3333"
+
+	gt.SetTableValue(fg.FenceTable, tokenID, &shared.CloudEvent[FenceData]{Data: FenceData{
+		H3Indexes: []string{"872ab259effffff"},
+	}})
+
+	const t0 = 1713818400000
+	// The first location approaches the zone and the third leaves it. The
+	// last is well away from it in time.
+	for _, e := range []*StatusEventV2[StatusV2Data]{
+		trajectoryEvent(t0, 42.261123478313145, -83.68613574673722),
+		trajectoryEvent(t0+30_000, 42.26172693660968, -83.71029708818693),
+		trajectoryEvent(t0+60_000, 42.261123478313145, -83.68613574673722),
+	} {
+		gt.Consume(string(fg.StatusInput), tokenID, e)
+	}
+
+	if _, _, ok := out.Next(); ok {
+		t.Fatal("Expected events to be held back")
+	}
+
+	gt.Consume(string(fg.StatusInput), tokenID, trajectoryEvent(t0+600_000, 42.261123478313145, -83.68613574673722))
+
+	for i := range 3 {
+		_, value, ok := out.Next()
+		if !ok {
+			t.Fatalf("Expected event %d to be released", i)
+		}
+		signals := value.(*StatusEventV2[StatusV2Data]).Data.Vehicle.Signals
+		if signals[0].Value != 42.25362819577089 || signals[1].Value != -83.68562802176137 {
+			t.Errorf("Expected event %d to be redacted but got %v, %v", i, signals[0].Value, signals[1].Value)
+		}
+		if signals[2].Value != true {
+			t.Errorf("Expected event %d to be marked as redacted", i)
+		}
+	}
+
+	if _, _, ok := out.Next(); ok {
+		t.Fatal("Expected the last event to be held back")
+	}
+
+	if err := fg.FlushTrajectories(context.TODO(), p, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, value, ok := out.Next()
+	if !ok {
+		t.Fatal("Expected the last event to be released by the flush")
+	}
+	if signals := value.(*StatusEventV2[StatusV2Data]).Data.Vehicle.Signals; signals[0].Value != 42.261123478313145 || signals[2].Value != false {
+		t.Errorf("Expected the last event to be in the clear but got %v", signals)
+	}
+
+	if v := gt.TableValue(goka.GroupTable(fg.Group), tokenID); v != nil {
+		t.Errorf("Expected the vehicle's state to be deleted but got %+v", v)
+	}
+}
+
+func TestFlushTrajectoriesLeavesIdleState(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := PrivacyV2{
+		Group:        "privacy-processor-v2",
+		StatusInput:  "topic.device.status.v2",
+		FenceTable:   "table.device.privacyfence.v2",
+		StatusOutput: "topic.device.status.private.v2",
+		Trajectory:   &Trajectory{Window: time.Minute},
+		Logger:       &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.DefineV2(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	table := goka.GroupTable(fg.Group)
+	gt.SetTableValue(table, "3333", &vehicleState{trajectoryState: trajectoryState{Ignition: ref(true)}})
+	changelog := gt.NewQueueTracker(string(table))
+
+	if err := fg.FlushTrajectories(context.TODO(), p, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, value, ok := changelog.Next(); ok {
+		t.Errorf("Expected the unchanged state not to be written but got %+v", value)
+	}
+}
+
+func TestTrajectoryState(t *testing.T) {
+	tr := &Trajectory{Window: time.Minute, Meters: 3000, MaxEvents: 2}
+	fence, _ := NewFence(FenceData{H3Indexes: []string{"872ab259effffff"}})
+	opts := locationOptions{signals: DefaultLocationSignals}
+	now := time.UnixMilli(1713818400000)
+
+	var s trajectoryState
+	s.hold(trajectoryEvent(1713818400000, 42.26172693660968, -83.71029708818693), tr, fence, ParentRedactor{}, opts, now)
+	s.hold(trajectoryEvent(1713818401000, 42.261123478313145, -83.68613574673722), tr, fence, ParentRedactor{}, opts, now)
+	s.hold(trajectoryEvent(1713818402000, 42.261123478313145, -83.68613574673722), tr, fence, ParentRedactor{}, opts, now)
+
+	if len(s.Anchors) != 1 {
+		t.Fatalf("Expected 1 anchor but got %d", len(s.Anchors))
+	}
+
+	if released := s.due(PipelineV2, tr); len(released) != 1 || released[0].Data.Timestamp != 1713818400000 {
+		t.Errorf("Expected the oldest event to be released beyond MaxEvents but got %v", released)
+	}
+
+	// About 2 km from the anchor, and an hour later.
+	far := h3.NewLatLng(42.261123478313145, -83.68613574673722)
+	later := now.Add(time.Hour)
+	if _, ok := s.nearZone(tr)(far, later); !ok {
+		t.Error("Expected a location within Meters of an anchor to be near the zone")
+	}
+	tr.Meters = 100
+	if _, ok := s.nearZone(tr)(far, later); ok {
+		t.Error("Expected a location beyond Meters and Window of every anchor not to be near the zone")
+	}
+	if a, ok := s.nearZone(tr)(far, now.Add(time.Minute)); !ok || a.OutLatitude != 42.25362819577089 {
+		t.Errorf("Expected a location within Window of an anchor to be redacted like it, got %+v", a)
+	}
+
+	s.prune(tr, later.UnixMilli())
+	if len(s.Anchors) != 0 {
+		t.Errorf("Expected old anchors to be pruned but got %v", s.Anchors)
+	}
+}
+
+func TestTrajectoryDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		trajectory Trajectory
+		delay      time.Duration
+		err        bool
+	}{
+		{"Window", Trajectory{Window: time.Minute}, time.Minute, false},
+		{"Delay", Trajectory{Window: time.Minute, Delay: 2 * time.Minute}, 2 * time.Minute, false},
+		{"ShortDelay", Trajectory{Window: time.Minute, Delay: time.Second}, time.Minute, false},
+		{"MetersWithDelay", Trajectory{Meters: 100, Delay: time.Minute}, time.Minute, false},
+		{"MetersOnly", Trajectory{Meters: 100}, 0, true},
+		{"Parked", Trajectory{Delay: 5 * time.Minute, TripEndpoints: &TripEndpoints{Window: time.Minute}}, 5 * time.Minute, false},
+		{"ParkedTooLong", Trajectory{Delay: 2 * time.Minute, TripEndpoints: &TripEndpoints{Window: time.Minute}}, 2 * time.Minute, true},
+		{"ParkedSooner", Trajectory{Delay: 2 * time.Minute, TripEndpoints: &TripEndpoints{Window: time.Minute, ParkedAfter: time.Minute}}, 2 * time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := tt.trajectory.delay(); d != tt.delay {
+				t.Errorf("Expected a delay of %s but got %s", tt.delay, d)
+			}
+			if err := tt.trajectory.Check(); (err != nil) != tt.err {
+				t.Errorf("Expected error %t but got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+// Defaults for FenceLimits.
+const (
+	// Parent snapping does nothing useful at resolution 0, and cells at
+	// resolution 15 are about a square meter.
+	defaultMinFenceResolution = 1
+	defaultMaxFenceResolution = 14
+	defaultMaxFenceCells      = 10000
+	// A large city.
+	defaultMaxFenceAreaKm2 = 1000
+)
+
+// FenceSourceInvalid is recorded when every location of a vehicle is redacted
+// because its fence is invalid. See Privacy.FenceFailClosed.
+const FenceSourceInvalid = "invalidFence"
+
+const fenceErrorEventType = "zone.dimo.privacy.fence.error"
+
+// FenceLimits bounds the fences that are accepted. Zero fields use the
+// defaults.
+type FenceLimits struct {
+	// MinResolution and MaxResolution bound the resolutions of fence cells
+	// and zones.
+	MinResolution int
+	MaxResolution int
+	// MaxCells bounds the number of cells in a fence, over all its zones.
+	MaxCells int
+	// MaxAreaKm2 bounds the total area of the shapes of a fence. Overlaps
+	// are counted twice, and polygon holes are not subtracted.
+	MaxAreaKm2 float64
+}
+
+// FenceError is the data of an event on the fence errors topic. It is keyed
+// by the vehicle whose message caused the fence to be read.
+type FenceError struct {
+	// Table and Key locate the fence. Key is the vehicle, or the owner or
+	// fleet for an inherited fence.
+	Table string `json:"table"`
+	Key   string `json:"key"`
+	// FenceID is the ID of the event that carried the fence.
+	FenceID string   `json:"fenceId,omitempty"`
+	Errors  []string `json:"errors"`
+}
+
+func (l FenceLimits) withDefaults() FenceLimits {
+	if l.MinResolution == 0 {
+		l.MinResolution = defaultMinFenceResolution
+	}
+	if l.MaxResolution == 0 {
+		l.MaxResolution = defaultMaxFenceResolution
+	}
+	if l.MaxCells == 0 {
+		l.MaxCells = defaultMaxFenceCells
+	}
+	if l.MaxAreaKm2 == 0 {
+		l.MaxAreaKm2 = defaultMaxFenceAreaKm2
+	}
+	return l
+}
+
+// check reports the ways in which f exceeds the limits.
+func (l FenceLimits) check(f *Fence) error {
+	if f == nil {
+		return nil
+	}
+	l = l.withDefaults()
+
+	var errs []error
+	cells := 0
+	area := 0.0
+	for i, z := range f.zones {
+		// As in NewFence, the first zone is the top level of FenceData.
+		prefix := ""
+		if i > 0 {
+			prefix = fmt.Sprintf("zone %d: ", i-1)
+		}
+
+		if len(z.polygons) != 0 || len(z.circles) != 0 {
+			if z.res < l.MinResolution || z.res > l.MaxResolution {
+				errs = append(errs, fmt.Errorf("%sresolution %d outside %d to %d", prefix, z.res, l.MinResolution, l.MaxResolution))
+			}
+		}
+		if z.cellRes != 0 && (z.cellRes < l.MinResolution || z.cellRes > l.MaxResolution) {
+			errs = append(errs, fmt.Errorf("%scell resolution %d outside %d to %d", prefix, z.cellRes, l.MinResolution, l.MaxResolution))
+		}
+		for _, g := range z.byRes {
+			if g.res < l.MinResolution || g.res > l.MaxResolution {
+				errs = append(errs, fmt.Errorf("%s%d cells with resolution %d outside %d to %d", prefix, len(g.cells), g.res, l.MinResolution, l.MaxResolution))
+			}
+		}
+
+		cells += len(z.cells)
+		for _, c := range z.cells {
+			area += h3.CellAreaKm2(c)
+		}
+		for _, p := range z.polygons {
+			area += p.areaKm2()
+		}
+		for _, c := range z.circles {
+			area += math.Pi * c.RadiusMeters * c.RadiusMeters / 1e6
+		}
+	}
+
+	if cells > l.MaxCells {
+		errs = append(errs, fmt.Errorf("%d cells, more than %d", cells, l.MaxCells))
+	}
+	if area > l.MaxAreaKm2 {
+		errs = append(errs, fmt.Errorf("area of %.0f km², more than %.0f", area, l.MaxAreaKm2))
+	}
+
+	return errors.Join(errs...)
+}
+
+// areaKm2 is the area enclosed by the exterior ring of p on a sphere.
+func (p polygon) areaKm2() float64 {
+	ring := p[0]
+	sum := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		a, b := ring[i], ring[i+1]
+		sum += (b.Lng - a.Lng) * math.Pi / 180 * (2 + math.Sin(a.Lat*math.Pi/180) + math.Sin(b.Lat*math.Pi/180))
+	}
+	return math.Abs(sum) * earthRadiusM * earthRadiusM / 2 / 1e6
+}
+
+// reportFence routes err, the error from reading the fence stored under key in
+// table, to stream, or only counts it if stream is empty.
+func reportFence(ctx goka.Context, pipeline string, stream goka.Stream, table goka.Table, key string, event *shared.CloudEvent[FenceData], err error) {
+	invalidFences.WithLabelValues(pipeline).Inc()
+
+	if stream == "" {
+		return
+	}
+
+	emit(ctx, pipeline, stream, &shared.CloudEvent[FenceError]{
+		ID:          fmt.Sprintf("%s-%d-%d", ctx.Topic(), ctx.Partition(), ctx.Offset()),
+		Source:      "privacy-processor",
+		SpecVersion: "1.0",
+		Subject:     ctx.Key(),
+		Time:        time.Now(),
+		Type:        fenceErrorEventType,
+		Data: FenceError{
+			Table:   string(table),
+			Key:     key,
+			FenceID: event.ID,
+			Errors:  strings.Split(err.Error(), "\n"),
+		},
+	})
+}
+
+// checkFence logs err, the error from reading fence for the current message,
+// and returns the fence to use. If failClosed is set, that is a fence that
+// removes every location.
+func checkFence(ctx goka.Context, logger *zerolog.Logger, fence *Fence, err error, failClosed bool) *Fence {
+	if err == nil {
+		return fence
+	}
+
+	if !failClosed {
+		logger.Err(err).Str("key", ctx.Key()).Msg("Privacy fence is invalid, using the valid parts of it.")
+		return fence
+	}
+
+	logger.Err(err).Str("key", ctx.Key()).Msg("Privacy fence is invalid, removing every location.")
+	out := (*Fence)(nil).orElse(RemoveRedactor{})
+	out.fallback.source = FenceSourceInvalid
+	return out
+}
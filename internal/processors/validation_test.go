@@ -0,0 +1,122 @@
+package processors
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/DIMO-Network/shared"
+	"github.com/lovoo/goka"
+	"github.com/lovoo/goka/tester"
+	"github.com/rs/zerolog"
+	"github.com/uber/h3-go/v4"
+)
+
+func TestFenceLimits(t *testing.T) {
+	inside := h3.NewLatLng(42.26172693660968, -83.71029708818693)
+
+	tests := []struct {
+		name   string
+		fence  FenceData
+		limits FenceLimits
+		err    string
+	}{
+		{"Valid", FenceData{H3Indexes: []string{"872ab259effffff"}}, FenceLimits{}, ""},
+		{"InvalidCell", FenceData{H3Indexes: []string{"872ab259effffff", "notacell"}}, FenceLimits{}, `invalid cell "notacell"`},
+		{"CoarseCell", FenceData{H3Indexes: []string{"8027fffffffffff"}}, FenceLimits{MaxAreaKm2: math.Inf(1)}, "resolution 0 outside 1 to 14"},
+		{"FineCell", FenceData{H3Indexes: []string{h3.LatLngToCell(inside, 15).String()}}, FenceLimits{}, "resolution 15 outside 1 to 14"},
+		{"ZoneResolution", FenceData{Zones: []Zone{{Circles: []Circle{{Latitude: 42.26, Longitude: -83.71, RadiusMeters: 100}}, Resolution: 12}}}, FenceLimits{MaxResolution: 10}, "zone 0: resolution 12 outside 1 to 10"},
+		{"ResolutionOutOfRange", FenceData{Circles: []Circle{{Latitude: 42.26, Longitude: -83.71, RadiusMeters: 100}}, Resolution: 16}, FenceLimits{}, "resolution 16 out of range"},
+		{"Cells", FenceData{H3Indexes: []string{"872ab259affffff", "872ab259effffff"}}, FenceLimits{MaxCells: 1}, "2 cells, more than 1"},
+		{"Area", FenceData{Circles: []Circle{{Latitude: 42.26, Longitude: -83.71, RadiusMeters: 50000}}}, FenceLimits{}, "area of 7854 km², more than 1000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&fenceCache{limits: tt.limits}).parse(tt.fence)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Expected an error containing %q but got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestPolygonArea(t *testing.T) {
+	polys, err := parseGeometry(Geometry{
+		Type:        "Polygon",
+		Coordinates: []byte(`[[[-83.72, 42.25], [-83.70, 42.25], [-83.70, 42.27], [-83.72, 42.27], [-83.72, 42.25]]]`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// About 1.65 km east to west and 2.22 km north to south.
+	if a := polys[0].areaKm2(); math.Abs(a-3.66) > 0.05 {
+		t.Errorf("Expected an area of about 3.66 km² but got %f", a)
+	}
+}
+
+func TestPrivacyInvalidFence(t *testing.T) {
+	gt := tester.New(t)
+	log := zerolog.Nop()
+
+	fg := Privacy{
+		Group:             "privacy-processor",
+		StatusInput:       "topic.device.status",
+		FenceTable:        "table.device.privacyfence",
+		StatusOutput:      "topic.device.status.private",
+		FenceErrorsOutput: "topic.privacy.fence.errors",
+		FenceFailClosed:   true,
+		Logger:            &log,
+	}
+
+	p, _ := goka.NewProcessor([]string{}, fg.Define(), goka.WithTester(gt))
+
+	go p.Run(context.TODO()) //nolint
+
+	out := gt.NewQueueTracker(string(fg.StatusOutput))
+	errs := gt.NewQueueTracker(string(fg.FenceErrorsOutput))
+
+	deviceID := "24c14Q2GGmXRT4JL0Gazu0MJ9XI"
+
+	gt.SetTableValue(fg.FenceTable, deviceID, &shared.CloudEvent[FenceData]{ID: "fence-1", Data: FenceData{
+		H3Indexes: []string{"872ab259effffff", "notacell"},
+	}})
+
+	// Far from the valid cell.
+	for range 2 {
+		gt.Consume(string(fg.StatusInput), deviceID, &shared.CloudEvent[StatusData]{Data: StatusData{
+			Latitude:  ref(42.3),
+			Longitude: ref(-83.8),
+			Overflow:  map[string]any{},
+		}})
+
+		_, value, ok := out.Next()
+		if !ok {
+			t.Fatal("No output")
+		}
+		if data := value.(*shared.CloudEvent[StatusData]).Data; data.Latitude != nil || data.RedactionSource != FenceSourceInvalid {
+			t.Errorf("Expected the location to be removed but got %+v", data)
+		}
+	}
+
+	key, value, ok := errs.Next()
+	if !ok || key != deviceID {
+		t.Fatalf("Expected a fence error for %s", deviceID)
+	}
+	event := value.(*shared.CloudEvent[FenceError])
+	if event.Type != fenceErrorEventType || event.Data.Table != string(fg.FenceTable) || event.Data.Key != deviceID || event.Data.FenceID != "fence-1" || len(event.Data.Errors) != 1 {
+		t.Errorf("Unexpected fence error %+v", event)
+	}
+
+	if _, _, ok := errs.Next(); ok {
+		t.Error("Expected the fence to be reported once")
+	}
+}
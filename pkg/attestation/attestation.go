@@ -0,0 +1,140 @@
+// Package attestation signs the payloads that the privacy processor emits and
+// lets consumers verify them.
+//
+// Signatures are Ethereum personal signatures (EIP-191) over the whole
+// CloudEvent except its signature attribute (see Payload), hex encoded with a
+// 0x prefix, so that the signer can be identified by its address. Covering
+// every other attribute keeps a signed payload from being replayed under
+// another subject, source or time, and binds the original signature of the
+// event to it.
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Attributes that hold signatures. Only SignatureAttribute is left out of the
+// signed payload.
+const (
+	SignatureAttribute         = "signature"
+	OriginalSignatureAttribute = "originalsignature"
+)
+
+// ErrMismatch is returned when a signature is valid but was not made by the
+// expected address.
+var ErrMismatch = errors.New("signature does not match address")
+
+// Signer signs payloads with a secp256k1 key.
+type Signer struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewSigner returns a signer for the given hex-encoded private key. A 0x
+// prefix is optional.
+func NewSigner(hexKey string) (*Signer, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(hexKey), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	return &Signer{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}, nil
+}
+
+// LoadSigner reads a hex-encoded private key from the file at path.
+func LoadSigner(path string) (*Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read signing key: %w", err)
+	}
+	return NewSigner(string(b))
+}
+
+// Address returns the address that verifiers should expect.
+func (s *Signer) Address() common.Address {
+	return s.address
+}
+
+// Sign returns the signature of data.
+func (s *Signer) Sign(data []byte) (string, error) {
+	sig, err := crypto.Sign(accounts.TextHash(data), s.key)
+	if err != nil {
+		return "", err
+	}
+	// Use the Ethereum convention for the recovery id.
+	sig[crypto.RecoveryIDOffset] += 27
+	return hexutil.Encode(sig), nil
+}
+
+// SignEvent returns the signature of event, a JSON CloudEvent.
+func (s *Signer) SignEvent(event []byte) (string, error) {
+	payload, err := Payload(event)
+	if err != nil {
+		return "", err
+	}
+	return s.Sign(payload)
+}
+
+// Payload returns the bytes that are signed for event, a JSON CloudEvent:
+// every top-level attribute except the signature, as an object with sorted
+// keys and no insignificant whitespace. Nested values are kept as they are.
+func Payload(event []byte) ([]byte, error) {
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(event, &attrs); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	delete(attrs, SignatureAttribute)
+	return json.Marshal(attrs)
+}
+
+// Verify checks that signature is a signature of data by address.
+func Verify(data []byte, signature string, address common.Address) error {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if len(sig) != crypto.SignatureLength {
+		return fmt.Errorf("invalid signature: length %d", len(sig))
+	}
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pub, err := crypto.SigToPub(accounts.TextHash(data), sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if crypto.PubkeyToAddress(*pub) != address {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// VerifyEvent checks the signature of a CloudEvent, as it was read from
+// Kafka, against address.
+func VerifyEvent(event []byte, address common.Address) error {
+	var e struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(event, &e); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+	if e.Signature == "" {
+		return errors.New("event is not signed")
+	}
+
+	payload, err := Payload(event)
+	if err != nil {
+		return err
+	}
+	return Verify(payload, e.Signature, address)
+}
@@ -0,0 +1,111 @@
+package attestation
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Well-known test key, not used anywhere real.
+const testKey = "0xb71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291"
+
+func TestSignAndVerify(t *testing.T) {
+	s, err := NewSigner(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := common.HexToAddress("0x71562b71999873DB5b286dF957af199Ec94617F7"); s.Address() != want {
+		t.Errorf("Expected address %s but got %s", want, s.Address())
+	}
+
+	data := []byte(`{"vehicle":{"signals":[{"name":"latitude","value":42.25}]}}`)
+
+	sig, err := s.Sign(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Verify(data, sig, s.Address()); err != nil {
+		t.Errorf("Expected the signature to verify: %v", err)
+	}
+	if err := Verify([]byte(`{"vehicle":{}}`), sig, s.Address()); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected a mismatch for altered data but got %v", err)
+	}
+	if err := Verify(data, sig, common.HexToAddress("0x01")); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected a mismatch for another address but got %v", err)
+	}
+	if err := Verify(data, "0x1234", s.Address()); err == nil || errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected a malformed signature error but got %v", err)
+	}
+}
+
+func TestVerifyEvent(t *testing.T) {
+	s, _ := NewSigner(testKey)
+
+	data := json.RawMessage(`{"timestamp":1713818407248}`)
+	attrs := map[string]any{
+		"id":                "2fbaXmHpdQiKyAH6o5hHTCYwU0U",
+		"subject":           "3333",
+		"data":              data,
+		"originalsignature": "0xdevice",
+	}
+
+	unsignedEvent, _ := json.Marshal(attrs)
+	sig, err := s.SignEvent(unsignedEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs["signature"] = sig
+
+	event, _ := json.MarshalIndent(attrs, "", "  ")
+	if err := VerifyEvent(event, s.Address()); err != nil {
+		t.Errorf("Expected the event to verify: %v", err)
+	}
+
+	attrs["originalsignature"] = "0xforged"
+	forged, _ := json.Marshal(attrs)
+	if err := VerifyEvent(forged, s.Address()); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected a replaced original signature to fail verification but got %v", err)
+	}
+
+	delete(attrs, "originalsignature")
+	stripped, _ := json.Marshal(attrs)
+	if err := VerifyEvent(stripped, s.Address()); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected a removed original signature to fail verification but got %v", err)
+	}
+	attrs["originalsignature"] = "0xdevice"
+
+	attrs["subject"] = "4444"
+	replayed, _ := json.Marshal(attrs)
+	if err := VerifyEvent(replayed, s.Address()); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected the data replayed under another subject to fail verification but got %v", err)
+	}
+
+	unsigned, _ := json.Marshal(map[string]any{"data": data})
+	if err := VerifyEvent(unsigned, s.Address()); err == nil {
+		t.Error("Expected an unsigned event to fail verification")
+	}
+}
+
+func TestLoadSigner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(testKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadSigner(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := common.HexToAddress("0x71562b71999873DB5b286dF957af199Ec94617F7"); s.Address() != want {
+		t.Errorf("Expected address %s but got %s", want, s.Address())
+	}
+
+	if _, err := NewSigner("not a key"); err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+}